@@ -0,0 +1,123 @@
+// batch.go
+//
+// Copyright 2018 © by Ollivier Robert <roberto@keltia.net>
+
+package observatory
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultWorkers is the number of scans running at the same time in ScanMany
+	DefaultWorkers = 4
+)
+
+// BatchOptions is for giving options to ScanMany
+type BatchOptions struct {
+	// Workers is the maximum number of scans running at the same time
+	Workers int
+	// Interval is the minimum delay between two scan submissions
+	Interval time.Duration
+	// Scan is for the submission options, DefaultScanOptions if nil
+	Scan *ScanOptions
+}
+
+// BatchResult is the outcome of the scan of one host
+type BatchResult struct {
+	Host     string
+	Analyze  *Analyze
+	Err      error
+	Start    time.Time
+	Duration time.Duration
+}
+
+// ScanMany scans all hosts with a bounded number of workers.  There is exactly one
+// result per host sent on the returned channel, in completion order; the channel is
+// closed once all hosts are done.  Hosts not scanned when ctx is cancelled get
+// the context error.  The caller must drain the channel.
+func (c *Client) ScanMany(ctx context.Context, hosts []string, opts BatchOptions) <-chan BatchResult {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	if workers > len(hosts) {
+		workers = len(hosts)
+	}
+
+	sopts := opts.Scan
+	if sopts == nil {
+		sopts = scanOptions(nil)
+	}
+
+	jobs := make(chan string)
+	results := make(chan BatchResult, workers)
+
+	var wg sync.WaitGroup
+
+	// Feed the workers at the given pace
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+
+		var tick <-chan time.Time
+		if opts.Interval > 0 {
+			t := time.NewTicker(opts.Interval)
+			defer t.Stop()
+			tick = t.C
+		}
+
+		for i, host := range hosts {
+			if i > 0 && tick != nil {
+				select {
+				case <-tick:
+				case <-ctx.Done():
+				}
+			}
+
+			if ctx.Err() == nil {
+				select {
+				case jobs <- host:
+					continue
+				case <-ctx.Done():
+				}
+			}
+
+			// Cancelled, every remaining host gets the error
+			for _, h := range hosts[i:] {
+				results <- BatchResult{Host: h, Err: ctx.Err(), Start: time.Now()}
+			}
+			return
+		}
+	}()
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for host := range jobs {
+				start := time.Now()
+				ar, err := c.getAnalyze(ctx, host, sopts)
+				results <- BatchResult{
+					Host:     host,
+					Analyze:  ar,
+					Err:      err,
+					Start:    start,
+					Duration: time.Since(start),
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	c.logger.Debug("ScanMany", "hosts", len(hosts), "workers", workers)
+	return results
+}
@@ -0,0 +1,139 @@
+package observatory
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// batchServer answers FINISHED scans except for bad.example.com and slowly for slow.example.com
+func batchServer(running, peak *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.URL.Query().Get("host")
+
+		if r.Method == "POST" {
+			n := atomic.AddInt32(running, 1)
+			for {
+				p := atomic.LoadInt32(peak)
+				if n <= p || atomic.CompareAndSwapInt32(peak, p, n) {
+					break
+				}
+			}
+			fmt.Fprint(w, `{"scan_id":1,"state":"PENDING"}`)
+			return
+		}
+
+		defer atomic.AddInt32(running, -1)
+
+		switch host {
+		case "bad.example.com":
+			fmt.Fprint(w, `{"scan_id":1,"state":"FAILED","error":"site down"}`)
+		case "slow.example.com":
+			time.Sleep(100 * time.Millisecond)
+			fallthrough
+		default:
+			fmt.Fprintf(w, `{"scan_id":1,"state":"FINISHED","grade":"A","end_time":%q}`,
+				time.Now().UTC().Format(time.RFC1123))
+		}
+	}))
+}
+
+func collect(ch <-chan BatchResult) map[string]BatchResult {
+	res := map[string]BatchResult{}
+	for r := range ch {
+		res[r.Host] = r
+	}
+	return res
+}
+
+func TestClient_ScanMany(t *testing.T) {
+	var running, peak int32
+
+	srv := batchServer(&running, &peak)
+	defer srv.Close()
+
+	c, err := NewClient(Config{BaseURL: srv.URL})
+	require.NoError(t, err)
+
+	hosts := []string{"slow.example.com", "bad.example.com"}
+	for i := 0; i < 10; i++ {
+		hosts = append(hosts, fmt.Sprintf("site%d.example.com", i))
+	}
+
+	res := collect(c.ScanMany(context.Background(), hosts, BatchOptions{Workers: 3}))
+	require.Len(t, res, len(hosts))
+
+	for _, h := range hosts {
+		r := res[h]
+		if h == "bad.example.com" {
+			assert.True(t, errors.Is(r.Err, ErrSiteDown))
+			continue
+		}
+		assert.NoError(t, r.Err, h)
+		assert.Equal(t, "A", r.Analyze.Grade, h)
+		assert.False(t, r.Start.IsZero())
+	}
+	assert.True(t, res["slow.example.com"].Duration >= 100*time.Millisecond)
+	assert.True(t, atomic.LoadInt32(&peak) <= 3)
+}
+
+func TestClient_ScanMany_Interval(t *testing.T) {
+	var running, peak int32
+
+	srv := batchServer(&running, &peak)
+	defer srv.Close()
+
+	c, err := NewClient(Config{BaseURL: srv.URL})
+	require.NoError(t, err)
+
+	hosts := []string{"a.example.com", "b.example.com", "c.example.com"}
+
+	start := time.Now()
+	res := collect(c.ScanMany(context.Background(), hosts, BatchOptions{Interval: 50 * time.Millisecond}))
+	require.Len(t, res, len(hosts))
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+}
+
+func TestClient_ScanMany_Cancel(t *testing.T) {
+	var running, peak int32
+
+	srv := batchServer(&running, &peak)
+	defer srv.Close()
+
+	c, err := NewClient(Config{BaseURL: srv.URL})
+	require.NoError(t, err)
+
+	hosts := []string{"a.example.com", "b.example.com", "c.example.com"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var once sync.Once
+
+	n := 0
+	for r := range c.ScanMany(ctx, hosts, BatchOptions{Workers: 1, Interval: time.Hour}) {
+		once.Do(cancel)
+		if r.Host != "a.example.com" {
+			assert.Equal(t, context.Canceled, r.Err)
+		}
+		n++
+	}
+	assert.Equal(t, len(hosts), n)
+}
+
+func TestClient_ScanMany_Empty(t *testing.T) {
+	c, err := NewClient()
+	require.NoError(t, err)
+
+	res := collect(c.ScanMany(context.Background(), nil, BatchOptions{}))
+	assert.Empty(t, res)
+}
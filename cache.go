@@ -0,0 +1,173 @@
+// cache.go
+//
+// Copyright 2018 © by Ollivier Robert <roberto@keltia.net>
+
+package observatory
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCacheTTL is how long a finished analysis is reused
+	DefaultCacheTTL = 10 * time.Minute
+
+	// DefaultCacheSize is the number of sites kept in the cache
+	DefaultCacheSize = 100
+)
+
+// CacheStats has the cache counters
+type CacheStats struct {
+	Hits   int
+	Misses int
+	Size   int
+}
+
+// entry is what we store for each site
+type entry struct {
+	host string
+	ar   *Analyze
+}
+
+// cache keeps the last analysis of each site, evicting the least recently used ones.
+// It is safe for concurrent use.
+type cache struct {
+	mu sync.Mutex
+
+	ttl   time.Duration
+	size  int
+	lru   *list.List
+	hosts map[string]*list.Element
+
+	hits   int
+	misses int
+}
+
+func newCache(ttl time.Duration, size int) *cache {
+	return &cache{
+		ttl:   ttl,
+		size:  size,
+		lru:   list.New(),
+		hosts: map[string]*list.Element{},
+	}
+}
+
+// get returns the analysis for host if it is still fresh
+func (c *cache) get(host string) (*Analyze, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	host = strings.ToLower(host)
+
+	el, ok := c.hosts[host]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+
+	ar := el.Value.(*entry).ar
+	if !isValid(ar, c.ttl) {
+		c.lru.Remove(el)
+		delete(c.hosts, host)
+		c.misses++
+		return nil, false
+	}
+
+	c.lru.MoveToFront(el)
+	c.hits++
+
+	// Callers get their own copy
+	return clone(ar), true
+}
+
+// clone copies ar with its headers, the copy shares nothing with ar
+func clone(ar *Analyze) *Analyze {
+	cp := *ar
+	if ar.ResponseHeaders != nil {
+		cp.ResponseHeaders = make(map[string]string, len(ar.ResponseHeaders))
+		for k, v := range ar.ResponseHeaders {
+			cp.ResponseHeaders[k] = v
+		}
+	}
+	return &cp
+}
+
+// put stores the analysis for host, evicting the oldest entry if needed
+func (c *cache) put(host string, ar *Analyze) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ttl <= 0 || c.size <= 0 {
+		return
+	}
+
+	host = strings.ToLower(host)
+
+	// Keep our own copy, the caller still has ar
+	ar = clone(ar)
+
+	if el, ok := c.hosts[host]; ok {
+		el.Value.(*entry).ar = ar
+		c.lru.MoveToFront(el)
+		return
+	}
+
+	c.hosts[host] = c.lru.PushFront(&entry{host: host, ar: ar})
+
+	for c.lru.Len() > c.size {
+		el := c.lru.Back()
+		c.lru.Remove(el)
+		delete(c.hosts, el.Value.(*entry).host)
+	}
+}
+
+// remove forgets about host
+func (c *cache) remove(host string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	host = strings.ToLower(host)
+
+	if el, ok := c.hosts[host]; ok {
+		c.lru.Remove(el)
+		delete(c.hosts, host)
+	}
+}
+
+// purge empties the cache, counters are kept
+func (c *cache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lru.Init()
+	c.hosts = map[string]*list.Element{}
+}
+
+func (c *cache) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return CacheStats{
+		Hits:   c.hits,
+		Misses: c.misses,
+		Size:   c.lru.Len(),
+	}
+}
+
+// Invalidate removes the cached analysis for site
+func (c *Client) Invalidate(site string) {
+	c.cache.remove(site)
+}
+
+// Purge removes all cached analysis
+func (c *Client) Purge() {
+	c.cache.purge()
+}
+
+// CacheStats returns the cache hits/misses counters and its current size
+func (c *Client) CacheStats() CacheStats {
+	return c.cache.stats()
+}
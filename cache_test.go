@@ -0,0 +1,198 @@
+package observatory
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fresh(grade string) *Analyze {
+	return &Analyze{
+		Grade:   grade,
+		State:   StateFinished,
+		EndTime: time.Now().UTC().Format(time.RFC1123),
+	}
+}
+
+func TestCache_GetPut(t *testing.T) {
+	c := newCache(DefaultCacheTTL, DefaultCacheSize)
+
+	_, ok := c.get("a.example.com")
+	assert.False(t, ok)
+
+	c.put("a.example.com", fresh("A"))
+	c.put("b.example.com", fresh("B"))
+
+	ar, ok := c.get("a.example.com")
+	require.True(t, ok)
+	assert.Equal(t, "A", ar.Grade)
+
+	ar, ok = c.get("B.example.com")
+	require.True(t, ok)
+	assert.Equal(t, "B", ar.Grade)
+
+	assert.Equal(t, CacheStats{Hits: 2, Misses: 1, Size: 2}, c.stats())
+}
+
+func TestCache_Copy(t *testing.T) {
+	c := newCache(DefaultCacheTTL, DefaultCacheSize)
+
+	ar := fresh("A")
+	ar.ResponseHeaders = map[string]string{"server": "nginx"}
+	c.put("a.example.com", ar)
+	ar.Grade = "F"
+	ar.ResponseHeaders["server"] = "apache"
+
+	got, ok := c.get("a.example.com")
+	require.True(t, ok)
+	assert.Equal(t, "A", got.Grade)
+	assert.Equal(t, "nginx", got.ResponseHeaders["server"])
+
+	// Nor with the entry we got
+	got.ResponseHeaders["server"] = "iis"
+
+	got, ok = c.get("a.example.com")
+	require.True(t, ok)
+	assert.Equal(t, "nginx", got.ResponseHeaders["server"])
+}
+
+func TestCache_Expired(t *testing.T) {
+	c := newCache(time.Minute, DefaultCacheSize)
+
+	old := fresh("A")
+	old.EndTime = time.Now().Add(-2 * time.Minute).UTC().Format(time.RFC1123)
+	c.put("a.example.com", old)
+
+	_, ok := c.get("a.example.com")
+	assert.False(t, ok)
+	assert.Equal(t, CacheStats{Misses: 1}, c.stats())
+}
+
+func TestCache_Disabled(t *testing.T) {
+	c := newCache(-1, DefaultCacheSize)
+
+	c.put("a.example.com", fresh("A"))
+	_, ok := c.get("a.example.com")
+	assert.False(t, ok)
+}
+
+func TestCache_Evict(t *testing.T) {
+	c := newCache(DefaultCacheTTL, 2)
+
+	c.put("a", fresh("A"))
+	c.put("b", fresh("B"))
+
+	// a is now the most recently used
+	_, ok := c.get("a")
+	require.True(t, ok)
+
+	c.put("c", fresh("C"))
+
+	_, ok = c.get("b")
+	assert.False(t, ok)
+	_, ok = c.get("a")
+	assert.True(t, ok)
+	_, ok = c.get("c")
+	assert.True(t, ok)
+	assert.Equal(t, 2, c.stats().Size)
+}
+
+func TestCache_Update(t *testing.T) {
+	c := newCache(DefaultCacheTTL, 2)
+
+	c.put("a", fresh("A"))
+	c.put("a", fresh("B"))
+
+	ar, ok := c.get("a")
+	require.True(t, ok)
+	assert.Equal(t, "B", ar.Grade)
+	assert.Equal(t, 1, c.stats().Size)
+}
+
+func TestCache_RemovePurge(t *testing.T) {
+	c := newCache(DefaultCacheTTL, DefaultCacheSize)
+
+	c.put("a", fresh("A"))
+	c.put("b", fresh("B"))
+
+	c.remove("A")
+	_, ok := c.get("a")
+	assert.False(t, ok)
+	assert.Equal(t, 1, c.stats().Size)
+
+	c.purge()
+	_, ok = c.get("b")
+	assert.False(t, ok)
+	assert.Equal(t, 0, c.stats().Size)
+}
+
+// analyzeServer answers a FINISHED analysis whose grade is the host name
+func analyzeServer() (*httptest.Server, *int32) {
+	var calls int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		host := r.URL.Query().Get("host")
+		fmt.Fprintf(w, `{"scan_id":1,"state":"FINISHED","grade":%q,"end_time":%q}`,
+			host, time.Now().UTC().Format(time.RFC1123))
+	}))
+	return srv, &calls
+}
+
+func TestClient_Cache(t *testing.T) {
+	srv, calls := analyzeServer()
+	defer srv.Close()
+
+	c, err := NewClient(Config{BaseURL: srv.URL})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	ar, err := c.getAnalyze(ctx, "a.example.com", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "a.example.com", ar.Grade)
+
+	ar, err = c.getAnalyze(ctx, "b.example.com", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "b.example.com", ar.Grade)
+
+	ar, err = c.getAnalyze(ctx, "a.example.com", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "a.example.com", ar.Grade)
+	assert.EqualValues(t, 2, atomic.LoadInt32(calls))
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 2, Size: 2}, c.CacheStats())
+
+	c.Invalidate("a.example.com")
+	_, err = c.getAnalyze(ctx, "a.example.com", nil)
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, atomic.LoadInt32(calls))
+
+	c.Purge()
+	assert.Equal(t, 0, c.CacheStats().Size)
+}
+
+func TestClient_Cache_Copy(t *testing.T) {
+	srv, calls := analyzeServer()
+	defer srv.Close()
+
+	c, err := NewClient(Config{BaseURL: srv.URL})
+	require.NoError(t, err)
+
+	ar, err := c.GetLatestAnalysis("a.example.com")
+	require.NoError(t, err)
+
+	// Not the cached one
+	ar.Grade = "F"
+
+	grade, err := c.GetCachedGrade("a.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "a.example.com", grade)
+	assert.EqualValues(t, 1, atomic.LoadInt32(calls))
+}
@@ -13,6 +13,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/keltia/observatory"
 )
@@ -60,7 +61,14 @@ func main() {
 		MyName, MyVersion, observatory.Version())
 
 	// Setup client
-	c, err := observatory.NewClient(observatory.Config{Log: level})
+	cnf := observatory.Config{Log: level}
+	if fVerbose {
+		cnf.Progress = func(p observatory.Progress) {
+			fmt.Fprintf(os.Stderr, "%s: %s (check %d, %v)\n", p.Host, p.Stage, p.Retry, p.Elapsed.Round(time.Second))
+		}
+	}
+
+	c, err := observatory.NewClient(cnf)
 	if err != nil {
 		log.Fatalf("error setting up client: %v", err)
 	}
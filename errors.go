@@ -0,0 +1,127 @@
+// errors.go
+//
+// Copyright 2018 © by Ollivier Robert <roberto@keltia.net>
+
+package observatory
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Errors returned by the client, use errors.Is() to check for them.
+var (
+	// ErrEmptySite is returned when no site name has been given
+	ErrEmptySite = errors.New("empty site")
+
+	// ErrInvalidHostname is returned when the API refuses the site name
+	ErrInvalidHostname = errors.New("invalid hostname")
+
+	// ErrRescanTooSoon is returned when a rescan is asked too soon after the previous one
+	ErrRescanTooSoon = errors.New("rescan attempt too soon")
+
+	// ErrRecentScanNotFound is returned when there is no recent scan for the site
+	ErrRecentScanNotFound = errors.New("recent scan not found")
+
+	// ErrNoScanYet is returned when the site has never been successfully scanned
+	ErrNoScanYet = errors.New("no scan yet")
+
+	// ErrScanNotFound is returned when the scan ID is unknown
+	ErrScanNotFound = errors.New("scan not found")
+
+	// ErrScannerDown is returned when the Observatory can not process requests
+	ErrScannerDown = errors.New("scanner down")
+
+	// ErrSiteDown is returned when the site could not be reached by the scanner
+	ErrSiteDown = errors.New("site down")
+
+	// ErrScanFailed is returned when the analysis ends in the FAILED or ABORTED state
+	ErrScanFailed = errors.New("site analysis failed")
+
+	// ErrUnknownState is returned when the API answers with a state we do not know about
+	ErrUnknownState = errors.New("unknown scan state")
+
+	// ErrNotSupported is returned for calls the selected API version does not have
+	ErrNotSupported = errors.New("not supported by this API version")
+
+	// ErrDetachedHandle is returned when a ScanHandle is used without a Client, see Attach
+	ErrDetachedHandle = errors.New("scan handle not attached to a client")
+
+	// ErrRetriesExceeded is returned when the scan is still not finished after all retries
+	ErrRetriesExceeded = errors.New("retries exceeded")
+)
+
+// apiErrors maps the API error codes to our own errors
+var apiErrors = map[string]error{
+	"invalid-hostname":             ErrInvalidHostname,
+	"invalid-hostname-ip":          ErrInvalidHostname,
+	"invalid-hostname-lookup":      ErrInvalidHostname,
+	"rescan-attempt-too-soon":      ErrRescanTooSoon,
+	"recent-scan-not-found":        ErrRecentScanNotFound,
+	"scan-not-found":               ErrScanNotFound,
+	"invalid-scan-id":              ErrScanNotFound,
+	"scanner-down-try-again-soon":  ErrScannerDown,
+	"database-down-try-again-soon": ErrScannerDown,
+	"site down":                    ErrSiteDown,
+	"No history found":             ErrNoScanYet,
+}
+
+// APIError is the error reported by the API in the "error" field of its answer
+type APIError struct {
+	Code  string    `json:"error"`
+	Text  string    `json:"text"`
+	State ScanState `json:"state"`
+
+	// Message is the v2 API version of Text
+	Message string `json:"message"`
+}
+
+// Error implements the error interface
+func (e *APIError) Error() string {
+	if e.Text != "" {
+		return fmt.Sprintf("%s: %s", e.Code, e.Text)
+	}
+	if e.Message != "" {
+		return fmt.Sprintf("%s: %s", e.Code, e.Message)
+	}
+	return e.Code
+}
+
+// Is allows errors.Is() to match the API error against our own errors
+func (e *APIError) Is(target error) bool {
+	if target == ErrScanFailed {
+		return e.State == StateFailed || e.State == StateAborted
+	}
+	return apiErrors[e.Code] == target
+}
+
+// HTTPError is returned when the API answers with anything but 200
+type HTTPError struct {
+	StatusCode int
+	Status     string
+	Body       []byte
+
+	// Err is the decoded error from the body, if any
+	Err error
+}
+
+// Error implements the error interface
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("status: %v body: %q", e.Status, e.Body)
+}
+
+// Unwrap gives access to the API error, if any
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+// apiError looks for the "error" field in a JSON answer, returns nil if not present
+func apiError(body []byte) error {
+	var e APIError
+
+	if err := json.Unmarshal(body, &e); err != nil || e.Code == "" {
+		return nil
+	}
+	return &e
+}
@@ -0,0 +1,78 @@
+package observatory
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIError_Error(t *testing.T) {
+	e := &APIError{Code: "site down"}
+	assert.Equal(t, "site down", e.Error())
+
+	e = &APIError{Code: "rescan-attempt-too-soon", Text: "Rescans attempts cannot be made more often than every 3 minutes"}
+	assert.Equal(t, "rescan-attempt-too-soon: Rescans attempts cannot be made more often than every 3 minutes", e.Error())
+
+	e = &APIError{Code: "invalid-hostname-lookup", Message: "example.invalid cannot be resolved"}
+	assert.Equal(t, "invalid-hostname-lookup: example.invalid cannot be resolved", e.Error())
+}
+
+func TestAPIError_Is(t *testing.T) {
+	testData := []struct {
+		Code string
+		Err  error
+	}{
+		{"invalid-hostname", ErrInvalidHostname},
+		{"invalid-hostname-ip", ErrInvalidHostname},
+		{"invalid-hostname-lookup", ErrInvalidHostname},
+		{"rescan-attempt-too-soon", ErrRescanTooSoon},
+		{"recent-scan-not-found", ErrRecentScanNotFound},
+		{"scan-not-found", ErrScanNotFound},
+		{"invalid-scan-id", ErrScanNotFound},
+		{"scanner-down-try-again-soon", ErrScannerDown},
+		{"site down", ErrSiteDown},
+		{"No history found", ErrNoScanYet},
+	}
+
+	for _, td := range testData {
+		err := errors.Wrap(&APIError{Code: td.Code}, "wrapped")
+		assert.True(t, errors.Is(err, td.Err), td.Code)
+		assert.False(t, errors.Is(err, ErrScanFailed), td.Code)
+	}
+}
+
+func TestAPIError_IsFailed(t *testing.T) {
+	err := &APIError{Code: "site down", State: "FAILED"}
+	assert.True(t, errors.Is(err, ErrScanFailed))
+	assert.True(t, errors.Is(err, ErrSiteDown))
+}
+
+func TestAPIError_Unknown(t *testing.T) {
+	err := &APIError{Code: "foo"}
+	assert.False(t, errors.Is(err, ErrSiteDown))
+}
+
+func TestHTTPError(t *testing.T) {
+	e := &HTTPError{StatusCode: 503, Status: "503 Service Unavailable", Body: []byte("down")}
+	assert.Equal(t, `status: 503 Service Unavailable body: "down"`, e.Error())
+	assert.Nil(t, e.Unwrap())
+
+	var he *HTTPError
+
+	err := errors.Wrap(e, "callAPI")
+	require.True(t, errors.As(err, &he))
+	assert.Equal(t, 503, he.StatusCode)
+}
+
+func TestApiError(t *testing.T) {
+	assert.Nil(t, apiError([]byte(`[{"grade":"A"}]`)))
+	assert.Nil(t, apiError([]byte(`{"grade":"A","error":null}`)))
+	assert.Nil(t, apiError([]byte(`<html>`)))
+
+	err := apiError([]byte(`{"error":"site down","state":"FAILED"}`))
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrSiteDown))
+	assert.True(t, errors.Is(err, ErrScanFailed))
+}
@@ -0,0 +1,103 @@
+// flight.go
+//
+// Copyright 2018 © by Ollivier Robert <roberto@keltia.net>
+
+package observatory
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+/*
+Concurrent analyses of the same site with the same options share one submission
+and one poll loop.  The shared work runs with its own context, cancelled when the
+last caller waiting for it is gone.
+*/
+
+// flight is an analysis in progress
+type flight struct {
+	done   chan struct{}
+	cancel context.CancelFunc
+
+	// Protected by flights.mu
+	waiters int
+
+	// Set before done is closed
+	ar  *Analyze
+	err error
+}
+
+// flights are the analyses in progress, by key
+type flights struct {
+	mu    sync.Mutex
+	calls map[string]*flight
+}
+
+func newFlights() *flights {
+	return &flights{calls: map[string]*flight{}}
+}
+
+// flightKey identifies an analysis
+func flightKey(site string, sopts *ScanOptions) string {
+	key := strings.ToLower(site)
+	if sopts == nil {
+		return key + "|get"
+	}
+	return fmt.Sprintf("%s|%t|%t|%t", key, sopts.Hidden, sopts.Rescan, sopts.NoWait)
+}
+
+// do runs fn for key unless it is already running, then waits for its result or
+// for ctx to be done.  Each caller gets its own copy of the result.
+func (f *flights) do(ctx context.Context, key string, fn func(context.Context) (*Analyze, error)) (*Analyze, bool, error) {
+	f.mu.Lock()
+	fl, shared := f.calls[key]
+	if !shared {
+		fctx, cancel := context.WithCancel(context.Background())
+		fl = &flight{done: make(chan struct{}), cancel: cancel}
+		f.calls[key] = fl
+
+		go func() {
+			fl.ar, fl.err = fn(fctx)
+
+			f.forget(key, fl)
+			cancel()
+			close(fl.done)
+		}()
+	}
+	fl.waiters++
+	f.mu.Unlock()
+
+	select {
+	case <-fl.done:
+		if fl.ar == nil {
+			return &Analyze{}, shared, fl.err
+		}
+		return clone(fl.ar), shared, fl.err
+
+	case <-ctx.Done():
+		f.mu.Lock()
+		fl.waiters--
+		if fl.waiters == 0 {
+			// Nobody is interested anymore
+			fl.cancel()
+			if f.calls[key] == fl {
+				delete(f.calls, key)
+			}
+		}
+		f.mu.Unlock()
+		return &Analyze{}, shared, ctx.Err()
+	}
+}
+
+// forget removes fl unless it has already been replaced
+func (f *flights) forget(key string, fl *flight) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.calls[key] == fl {
+		delete(f.calls, key)
+	}
+}
@@ -0,0 +1,214 @@
+package observatory
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// slowServer answers the GETs once release is closed
+func slowServer(posts, gets *int32, release chan struct{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			atomic.AddInt32(posts, 1)
+			fmt.Fprint(w, `{"scan_id":1,"state":"PENDING"}`)
+			return
+		}
+
+		atomic.AddInt32(gets, 1)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		fmt.Fprint(w, finished)
+	}))
+}
+
+// eventually waits for cond to be true, at most one second
+func eventually(t *testing.T, cond func() bool) {
+	for end := time.Now().Add(time.Second); time.Now().Before(end); time.Sleep(time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatal("condition never met")
+}
+
+func newFlightClient(t *testing.T, url string) *Client {
+	c, err := NewClient(Config{BaseURL: url, CacheTTL: -1, RescanCooldown: -1})
+	require.NoError(t, err)
+	return c
+}
+
+func TestFlightKey(t *testing.T) {
+	assert.Equal(t, "www.example.com|get", flightKey("WWW.example.com", nil))
+	assert.Equal(t, "www.example.com|true|true|false", flightKey("www.example.com", &DefaultScanOptions))
+	assert.NotEqual(t, flightKey("www.example.com", &ScanOptions{Rescan: true}), flightKey("www.example.com", &DefaultScanOptions))
+}
+
+func TestFlights_Copy(t *testing.T) {
+	f := newFlights()
+
+	orig := &Analyze{Grade: "A", ResponseHeaders: map[string]string{"server": "nginx"}}
+	release := make(chan struct{})
+	fn := func(context.Context) (*Analyze, error) {
+		<-release
+		return orig, nil
+	}
+
+	var (
+		wg  sync.WaitGroup
+		ars [2]*Analyze
+	)
+	for i := range ars {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ars[i], _, _ = f.do(context.Background(), "key", fn)
+		}(i)
+	}
+
+	eventually(t, func() bool {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return f.calls["key"] != nil && f.calls["key"].waiters == 2
+	})
+	close(release)
+	wg.Wait()
+
+	// Nothing shared between the callers
+	ars[0].ResponseHeaders["server"] = "apache"
+	assert.Equal(t, "nginx", ars[1].ResponseHeaders["server"])
+	assert.Equal(t, "nginx", orig.ResponseHeaders["server"])
+}
+
+func TestClient_GetGrade_Shared(t *testing.T) {
+	var posts, gets int32
+
+	release := make(chan struct{})
+
+	srv := slowServer(&posts, &gets, release)
+	defer srv.Close()
+
+	c := newFlightClient(t, srv.URL)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			grade, err := c.GetGrade("www.example.com")
+			assert.NoError(t, err)
+			assert.Equal(t, "A", grade)
+		}()
+	}
+
+	// Other options, other scan
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		_, err := c.GetGrade("www.example.com", ScanOptions{Rescan: true})
+		assert.NoError(t, err)
+	}()
+
+	eventually(t, func() bool { return atomic.LoadInt32(&gets) == 2 })
+	close(release)
+	wg.Wait()
+
+	assert.EqualValues(t, 2, atomic.LoadInt32(&posts))
+	assert.EqualValues(t, 2, atomic.LoadInt32(&gets))
+	assert.Empty(t, c.flights.calls)
+}
+
+func TestClient_GetGrade_SharedCancel(t *testing.T) {
+	var posts, gets int32
+
+	release := make(chan struct{})
+
+	srv := slowServer(&posts, &gets, release)
+	defer srv.Close()
+
+	c := newFlightClient(t, srv.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	errc := make(chan error, 2)
+	go func() {
+		_, err := c.GetGradeContext(ctx, "www.example.com")
+		errc <- err
+	}()
+	go func() {
+		_, err := c.GetGrade("www.example.com")
+		errc <- err
+	}()
+
+	eventually(t, func() bool {
+		c.flights.mu.Lock()
+		defer c.flights.mu.Unlock()
+
+		fl := c.flights.calls[flightKey("www.example.com", &DefaultScanOptions)]
+		return fl != nil && fl.waiters == 2
+	})
+
+	// The first caller leaves, the other one still gets the result
+	cancel()
+	assert.True(t, errors.Is(<-errc, context.Canceled))
+
+	close(release)
+	assert.NoError(t, <-errc)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&posts))
+}
+
+func TestClient_GetGrade_SharedAbandoned(t *testing.T) {
+	var posts, gets int32
+
+	release := make(chan struct{})
+	defer close(release)
+
+	srv := slowServer(&posts, &gets, release)
+	defer srv.Close()
+
+	c := newFlightClient(t, srv.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		_, err := c.GetGradeContext(ctx, "www.example.com")
+		assert.True(t, errors.Is(err, context.Canceled))
+	}()
+
+	eventually(t, func() bool { return atomic.LoadInt32(&gets) == 1 })
+
+	c.flights.mu.Lock()
+	fl := c.flights.calls[flightKey("www.example.com", &DefaultScanOptions)]
+	c.flights.mu.Unlock()
+	require.NotNil(t, fl)
+
+	// Nobody waits anymore, the shared analysis is cancelled
+	cancel()
+	<-done
+
+	select {
+	case <-fl.done:
+	case <-time.After(time.Second):
+		t.Fatal("shared analysis not cancelled")
+	}
+	assert.Error(t, fl.err)
+	assert.Empty(t, c.flights.calls)
+}
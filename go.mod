@@ -4,7 +4,7 @@ require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/h2non/gock v1.0.9
 	github.com/keltia/proxy v0.9.3
-	github.com/pkg/errors v0.8.0
+	github.com/pkg/errors v0.9.1
 	github.com/stretchr/testify v1.2.2
 )
 
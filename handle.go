@@ -0,0 +1,124 @@
+// handle.go
+//
+// Copyright 2018 © by Ollivier Robert <roberto@keltia.net>
+
+package observatory
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ScanHandle is a submitted scan, to be collected later with Wait or Poll.  It can be
+// saved as JSON and used by another Client after Attach.  It is not safe for
+// concurrent use.
+type ScanHandle struct {
+	Host      string      `json:"host"`
+	ScanID    int         `json:"scan_id"`
+	State     ScanState   `json:"state"`
+	Options   ScanOptions `json:"options"`
+	Submitted time.Time   `json:"submitted"`
+
+	c *Client
+}
+
+// StartScan submits a scan of site and returns without waiting for it
+func (c *Client) StartScan(ctx context.Context, site string, opts ...ScanOptions) (*ScanHandle, error) {
+	c.logger.Debug("StartScan", "host", site)
+
+	if site == "" {
+		return nil, ErrEmptySite
+	}
+
+	sopts := *scanOptions(opts)
+	sopts.NoWait = true
+
+	var (
+		ar  *Analyze
+		err error
+	)
+
+	// v2 scans are synchronous
+	if c.api == 2 {
+		ar, err = c.getAnalyzeV2(ctx, site, &sopts)
+	} else {
+		ar, _, err = c.submitScan(ctx, site, &sopts, time.Now())
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "StartScan")
+	}
+
+	return &ScanHandle{
+		Host:      site,
+		ScanID:    ar.ScanID,
+		State:     ar.State,
+		Options:   sopts,
+		Submitted: time.Now().UTC(),
+		c:         c,
+	}, nil
+}
+
+// Attach links h to c, needed for a handle loaded from JSON
+func (c *Client) Attach(h *ScanHandle) *ScanHandle {
+	h.c = c
+	return h
+}
+
+// Done is true when the scan is over, whatever the outcome
+func (h *ScanHandle) Done() bool {
+	return h.State.Done()
+}
+
+// Poll checks the scan once and updates the handle.  The Analyze is complete once
+// the handle is Done.
+func (h *ScanHandle) Poll(ctx context.Context) (*Analyze, error) {
+	if h.c == nil {
+		return &Analyze{}, ErrDetachedHandle
+	}
+
+	var (
+		ar  *Analyze
+		err error
+	)
+
+	if h.c.api == 2 {
+		ar, err = h.c.getAnalyzeV2(ctx, h.Host, nil)
+	} else {
+		ar, err = h.c.fetchAnalyze(ctx, h.Host)
+		if err == nil {
+			_, err = h.c.checkState(h.Host, ar, 0, h.Submitted)
+		} else if ar.State.Done() {
+			h.c.report(h.Host, StageFailed, ar, 0, h.Submitted, err)
+		}
+	}
+	h.update(ar)
+	return ar, errors.Wrap(err, "Poll")
+}
+
+// Wait polls the scan until it is done, like GetAnalysis does
+func (h *ScanHandle) Wait(ctx context.Context) (*Analyze, error) {
+	if h.c == nil {
+		return &Analyze{}, ErrDetachedHandle
+	}
+
+	if h.c.api == 2 {
+		return h.Poll(ctx)
+	}
+
+	ar, err := h.c.waitScan(ctx, h.Host, h.Submitted)
+	h.update(ar)
+	return ar, errors.Wrap(err, "Wait")
+}
+
+// update keeps the last known state, the current scan might be a newer one
+func (h *ScanHandle) update(ar *Analyze) {
+	if ar == nil || ar.State == "" {
+		return
+	}
+	h.State = ar.State
+	if ar.ScanID != 0 {
+		h.ScanID = ar.ScanID
+	}
+}
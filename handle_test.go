@@ -0,0 +1,136 @@
+package observatory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stateServer answers the POST with PENDING then each GET with the next state
+func stateServer(posts *int32, states ...ScanState) *httptest.Server {
+	var gets int32
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			atomic.AddInt32(posts, 1)
+			fmt.Fprint(w, `{"scan_id":42,"state":"PENDING"}`)
+			return
+		}
+
+		n := int(atomic.AddInt32(&gets, 1))
+		if n > len(states) {
+			n = len(states)
+		}
+		fmt.Fprintf(w, `{"scan_id":42,"state":%q,"grade":"B","end_time":"Mon, 12 Aug 2024 08:20:18 GMT"}`, states[n-1])
+	}))
+}
+
+func TestClient_StartScan(t *testing.T) {
+	var posts int32
+
+	srv := stateServer(&posts, StateRunning, StateFinished)
+	defer srv.Close()
+
+	c, err := NewClient(Config{BaseURL: srv.URL})
+	require.NoError(t, err)
+
+	h, err := c.StartScan(context.Background(), "www.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "www.example.com", h.Host)
+	assert.Equal(t, 42, h.ScanID)
+	assert.Equal(t, StatePending, h.State)
+	assert.True(t, h.Options.Rescan)
+	assert.False(t, h.Done())
+	assert.EqualValues(t, 1, atomic.LoadInt32(&posts))
+
+	// Save it for later
+	buf, err := json.Marshal(h)
+	require.NoError(t, err)
+
+	var nh ScanHandle
+
+	require.NoError(t, json.Unmarshal(buf, &nh))
+	assert.Equal(t, h.Submitted.Unix(), nh.Submitted.Unix())
+
+	_, err = nh.Wait(context.Background())
+	assert.Equal(t, ErrDetachedHandle, err)
+
+	// Another client picks it up
+	c2, err := NewClient(Config{BaseURL: srv.URL, PollInterval: time.Millisecond})
+	require.NoError(t, err)
+
+	ar, err := c2.Attach(&nh).Wait(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "B", ar.Grade)
+	assert.Equal(t, StateFinished, nh.State)
+	assert.True(t, nh.Done())
+	assert.EqualValues(t, 1, atomic.LoadInt32(&posts))
+}
+
+func TestScanHandle_Poll(t *testing.T) {
+	var posts int32
+
+	srv := stateServer(&posts, StateStarting, StateRunning, StateFailed)
+	defer srv.Close()
+
+	c, err := NewClient(Config{BaseURL: srv.URL})
+	require.NoError(t, err)
+
+	h, err := c.StartScan(context.Background(), "www.example.com", ScanOptions{Hidden: true})
+	require.NoError(t, err)
+	assert.False(t, h.Options.Rescan)
+
+	for _, st := range []ScanState{StateStarting, StateRunning} {
+		ar, err := h.Poll(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, st, ar.State)
+		assert.Equal(t, st, h.State)
+	}
+
+	_, err = h.Poll(context.Background())
+	assert.True(t, errors.Is(err, ErrScanFailed))
+	assert.Equal(t, StateFailed, h.State)
+	assert.True(t, h.Done())
+}
+
+func TestClient_StartScan_Errors(t *testing.T) {
+	c, err := NewClient()
+	require.NoError(t, err)
+
+	_, err = c.StartScan(context.Background(), "")
+	assert.Equal(t, ErrEmptySite, err)
+
+	var h ScanHandle
+
+	_, err = h.Poll(context.Background())
+	assert.Equal(t, ErrDetachedHandle, err)
+}
+
+func TestClient_StartScan_V2(t *testing.T) {
+	ftr, err := ioutil.ReadFile("testdata/mdn-scan.json")
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		fmt.Fprint(w, string(ftr))
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(Config{BaseURL: srv.URL, APIVersion: 2})
+	require.NoError(t, err)
+
+	h, err := c.StartScan(context.Background(), "mdn.dev")
+	require.NoError(t, err)
+	assert.Equal(t, 77666718, h.ScanID)
+	assert.True(t, h.Done())
+}
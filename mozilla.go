@@ -4,12 +4,12 @@ package observatory
 Not going to implement the full scan report struct, I do not need it, juste grade/score
 */
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"time"
 
-	"github.com/keltia/proxy"
 	"github.com/pkg/errors"
 )
 
@@ -22,6 +22,9 @@ const (
 	// DefaultRetry is the number of retries we allow
 	DefaultRetry = 5
 
+	// DefaultPollInterval is the delay between two checks of a PENDING scan
+	DefaultPollInterval = 2 * time.Second
+
 	// MyVersion is the API version
 	MyVersion = "1.3.1"
 
@@ -29,127 +32,273 @@ const (
 	MyName = "observatory"
 )
 
+// DefaultScanOptions are used when no ScanOptions are given
+var DefaultScanOptions = ScanOptions{Hidden: true, Rescan: true}
+
 // Public functions
 
-// NewClient setups proxy authentication
+// NewClient setups proxy authentication, only the first Config is used.  New is the
+// preferred way, this is kept for compatibility.
 func NewClient(cnf ...Config) (*Client, error) {
-	var c *Client
+	return newClient(baseURL, DefaultRetry, configOptions(cnf)...)
+}
 
+// newClient does the real work for the given API endpoint and number of retries
+func newClient(defURL string, defRetry int, opts ...Option) (*Client, error) {
 	// Set default
-	if len(cnf) == 0 {
-		c = &Client{
-			baseurl: baseURL,
-			timeout: DefaultWait,
-			retries: DefaultRetry,
-		}
-	} else {
-		c = &Client{
-			baseurl: cnf[0].BaseURL,
-			level:   cnf[0].Log,
-			retries: cnf[0].Retries,
-			timeout: toDuration(cnf[0].Timeout) * time.Second,
-		}
-
-		if cnf[0].Timeout == 0 {
-			c.timeout = DefaultWait
-		} else {
-			c.timeout = time.Duration(cnf[0].Timeout) * time.Second
-		}
+	c := &Client{
+		timeout: DefaultWait,
+		retries: defRetry,
+		poll:    DefaultPollInterval,
+		retry:   DefaultRetryPolicy,
+		cache:   newCache(DefaultCacheTTL, DefaultCacheSize),
+		api:     1,
+		agent:   fmt.Sprintf("%s/%s", MyName, MyVersion),
+
+		cooldowns: newCooldowns(DefaultRescanCooldown),
+		flights:   newFlights(),
+		scans:     newScanIndex(),
+	}
 
-		// Ensure proper default
-		if c.retries == 0 {
-			c.retries = DefaultRetry
-		}
-		// Ensure we have the API endpoint right
-		if c.baseurl == "" {
-			c.baseurl = baseURL
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, errors.Wrap(err, "newClient")
 		}
+	}
 
-		c.debug("got cnf: %#v", cnf[0])
+	if c.logger == nil {
+		c.logger = &stdLogger{level: c.level}
 	}
 
-	// We do not care whether it fails or not, if it does, just no proxyauth.
-	proxyauth, _ := proxy.SetupProxyAuth()
+	// Ensure we have the API endpoint right, v2 has its own
+	if c.baseurl == "" {
+		c.baseurl = defURL
+		if c.api == 2 && defURL == baseURL {
+			c.baseurl = baseURLv2
+		}
+	}
 
-	// Save it
-	c.proxyauth = proxyauth
-	c.debug("got proxyauth: %s", c.proxyauth)
+	if c.client == nil {
+		trsp, err := c.proxyTransport()
+		if err != nil {
+			return nil, errors.Wrap(err, "newClient")
+		}
 
-	_, trsp := proxy.SetupTransport(c.baseurl)
-	c.client = &http.Client{
-		Transport:     trsp,
-		Timeout:       c.timeout,
-		CheckRedirect: myRedirect,
+		c.client = &http.Client{
+			Transport:     trsp,
+			Timeout:       c.timeout,
+			CheckRedirect: myRedirect,
+		}
 	}
-	c.debug("mozilla: c=%#v", c)
+	c.logger.Debug("client ready", "baseurl", c.baseurl, "api", c.api,
+		"timeout", c.timeout, "retries", c.retries, "proxy", redactURL(c.proxy.url))
 	return c, nil
 }
 
 // GetScore returns the integer value of the grade
-func (c *Client) GetScore(site string) (score int, err error) {
-	c.debug("GetScore")
+func (c *Client) GetScore(site string, opts ...ScanOptions) (score int, err error) {
+	return c.GetScoreContext(context.Background(), site, opts...)
+}
 
-	ar, err := c.getAnalyze(site, true)
+// GetScoreContext is GetScore with a context to cancel the scan
+func (c *Client) GetScoreContext(ctx context.Context, site string, opts ...ScanOptions) (score int, err error) {
+	c.logger.Debug("GetScore", "host", site)
+
+	ar, err := c.getAnalyze(ctx, site, scanOptions(opts))
 	return ar.Score, errors.Wrap(err, "GetScore")
 }
 
 // GetGrade returns the letter equivalent to the score
-func (c *Client) GetGrade(site string) (grade string, err error) {
-	c.debug("GetGrade")
+func (c *Client) GetGrade(site string, opts ...ScanOptions) (grade string, err error) {
+	return c.GetGradeContext(context.Background(), site, opts...)
+}
+
+// GetGradeContext is GetGrade with a context to cancel the scan
+func (c *Client) GetGradeContext(ctx context.Context, site string, opts ...ScanOptions) (grade string, err error) {
+	c.logger.Debug("GetGrade", "host", site)
 
-	ar, err := c.getAnalyze(site, true)
+	ar, err := c.getAnalyze(ctx, site, scanOptions(opts))
 	return ar.Grade, errors.Wrap(err, "GetGrade")
 }
 
+// GetAnalysis returns the whole analysis of the site
+func (c *Client) GetAnalysis(site string, opts ...ScanOptions) (*Analyze, error) {
+	return c.GetAnalysisContext(context.Background(), site, opts...)
+}
+
+// GetAnalysisContext is GetAnalysis with a context to cancel the scan
+func (c *Client) GetAnalysisContext(ctx context.Context, site string, opts ...ScanOptions) (*Analyze, error) {
+	c.logger.Debug("GetAnalysis", "host", site)
+
+	ar, err := c.getAnalyze(ctx, site, scanOptions(opts))
+	return ar, errors.Wrap(err, "GetAnalysis")
+}
+
+// GetLatestAnalysis returns the most recent finished analysis without submitting a new scan
+func (c *Client) GetLatestAnalysis(site string) (*Analyze, error) {
+	return c.GetLatestAnalysisContext(context.Background(), site)
+}
+
+// GetLatestAnalysisContext is GetLatestAnalysis with a context to cancel the calls
+func (c *Client) GetLatestAnalysisContext(ctx context.Context, site string) (*Analyze, error) {
+	c.logger.Debug("GetLatestAnalysis", "host", site)
+
+	if site == "" {
+		return &Analyze{}, ErrEmptySite
+	}
+
+	if ar, ok := c.cache.get(site); ok {
+		return ar, nil
+	}
+
+	// v2 always has the last finished scan
+	if c.api == 2 {
+		ar, err := c.getAnalyzeV2(ctx, site, nil)
+		return ar, errors.Wrap(err, "GetLatestAnalysis")
+	}
+
+	opts := map[string]string{
+		"host": site,
+	}
+
+	var ar Analyze
+
+	// A failed current scan does not hide the finished ones
+	raw, err := c.callAPI(ctx, "GET", "analyze", nil, opts)
+	if err != nil && !errors.Is(err, ErrRecentScanNotFound) && !errors.Is(err, ErrScanFailed) {
+		return &Analyze{}, errors.Wrap(err, "GetLatestAnalysis")
+	}
+
+	if err == nil {
+		if err := json.Unmarshal(raw, &ar); err != nil {
+			return &Analyze{}, errors.Wrap(err, "GetLatestAnalysis/unmarshal")
+		}
+
+		if ar.State == StateFinished {
+			c.cache.put(site, &ar)
+			return &ar, nil
+		}
+	}
+
+	// Current scan not usable, look for the last finished one
+	c.logger.Debug("looking at history", "host", site, "state", ar.State)
+
+	hs, err := c.GetHostHistoryContext(ctx, site)
+	if errors.Is(err, ErrNoScanYet) || (err == nil && len(hs) == 0) {
+		return &Analyze{}, ErrNoScanYet
+	}
+	if err != nil {
+		return &Analyze{}, errors.Wrap(err, "GetLatestAnalysis")
+	}
+
+	last := hs[len(hs)-1]
+	return &Analyze{
+		Grade:   last.Grade,
+		Score:   last.Score,
+		ScanID:  last.ScanID,
+		EndTime: last.EndTime,
+		State:   StateFinished,
+	}, nil
+}
+
+// GetCachedGrade returns the grade of the most recent finished scan without submitting a new one
+func (c *Client) GetCachedGrade(site string) (string, error) {
+	return c.GetCachedGradeContext(context.Background(), site)
+}
+
+// GetCachedGradeContext is GetCachedGrade with a context to cancel the calls
+func (c *Client) GetCachedGradeContext(ctx context.Context, site string) (string, error) {
+	ar, err := c.GetLatestAnalysisContext(ctx, site)
+	return ar.Grade, err
+}
+
 // GetScanID returns the scan ID for the most recent run
 func (c *Client) GetScanID(site string) (int, error) {
-	c.debug("GetScanID")
+	return c.GetScanIDContext(context.Background(), site)
+}
+
+// GetScanIDContext is GetScanID with a context to cancel the call
+func (c *Client) GetScanIDContext(ctx context.Context, site string) (int, error) {
+	c.logger.Debug("GetScanID", "host", site)
 
-	ar, err := c.getAnalyze(site, false)
+	ar, err := c.getAnalyze(ctx, site, nil)
 	return ar.ScanID, errors.Wrap(err, "GetScanID failed")
 }
 
 // GetScanResults returns the full scan report
 func (c *Client) GetScanResults(scanID int) ([]byte, error) {
-	c.debug("GetScanResults")
+	return c.GetScanResultsContext(context.Background(), scanID)
+}
+
+// GetScanResultsContext is GetScanResults with a context to cancel the call
+func (c *Client) GetScanResultsContext(ctx context.Context, scanID int) ([]byte, error) {
+	c.logger.Debug("GetScanResults", "scan_id", scanID)
+
+	if c.api == 2 {
+		s, err := c.scanResultsV2(ctx, scanID)
+		return s, errors.Wrap(err, "GetScanResults")
+	}
 
 	opts := map[string]string{
 		"scan": fmt.Sprintf("%d", scanID),
 	}
 
-	s, err := c.callAPI("GET", "getScanResults", "", opts)
+	s, err := c.callAPI(ctx, "GET", "getScanResults", nil, opts)
 
 	// Return raw json
 	return s, errors.Wrap(err, "GetScanResults")
 }
 
-// GetScanReport returns the full scan report
-func (c *Client) GetScanReport(scanID int) ([]byte, error) {
-	c.debug("GetScanReport (deprecated)")
+// GetTestResults returns the decoded scan report
+func (c *Client) GetTestResults(scanID int) (*Result, error) {
+	return c.GetTestResultsContext(context.Background(), scanID)
+}
 
-	opts := map[string]string{
-		"scan": fmt.Sprintf("%d", scanID),
+// GetTestResultsContext is GetTestResults with a context to cancel the call
+func (c *Client) GetTestResultsContext(ctx context.Context, scanID int) (*Result, error) {
+	c.logger.Debug("GetTestResults", "scan_id", scanID)
+
+	raw, err := c.GetScanResultsContext(ctx, scanID)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetTestResults")
 	}
 
-	s, err := c.callAPI("GET", "getScanResults", "", opts)
+	var res Result
+
+	err = json.Unmarshal(raw, &res)
+	return &res, errors.Wrap(err, "GetTestResults/unmarshal")
+}
 
-	// Return raw json
-	return s, errors.Wrap(err, "GetScanResults")
+// GetScanReport returns the full scan report
+func (c *Client) GetScanReport(scanID int) ([]byte, error) {
+	c.logger.Debug("GetScanReport (deprecated)", "scan_id", scanID)
+
+	return c.GetScanResults(scanID)
 }
 
 // GetHostHistory returns the list of recent scans
 func (c *Client) GetHostHistory(site string) ([]HostHistory, error) {
-	c.debug("GetSiteHistory")
+	return c.GetHostHistoryContext(context.Background(), site)
+}
+
+// GetHostHistoryContext is GetHostHistory with a context to cancel the call
+func (c *Client) GetHostHistoryContext(ctx context.Context, site string) ([]HostHistory, error) {
+	c.logger.Debug("GetHostHistory", "host", site)
 
 	if site == "" {
-		return nil, errors.New("empty site")
+		return nil, ErrEmptySite
+	}
+
+	if c.api == 2 {
+		hs, err := c.hostHistoryV2(ctx, site)
+		return hs, errors.Wrap(err, "GetHostHistory failed")
 	}
 
 	opts := map[string]string{
 		"host": site,
 	}
 
-	s, err := c.callAPI("GET", "getHostHistory", "", opts)
+	s, err := c.callAPI(ctx, "GET", "getHostHistory", nil, opts)
 	if err != nil {
 		return []HostHistory{}, errors.Wrap(err, "GetHostHistory failed")
 	}
@@ -162,24 +311,49 @@ func (c *Client) GetHostHistory(site string) ([]HostHistory, error) {
 
 // IsHTTPSonly checks whether a redir from http to https exist
 func (c *Client) IsHTTPSonly(site string) (bool, error) {
-	scanid, err := c.GetScanID(site)
+	return c.IsHTTPSonlyContext(context.Background(), site)
+}
+
+// IsHTTPSonlyContext is IsHTTPSonly with a context to cancel the calls
+func (c *Client) IsHTTPSonlyContext(ctx context.Context, site string) (bool, error) {
+	res, err := c.siteResults(ctx, site)
 	if err != nil {
-		return false, errors.Wrap(err, "GetScanID")
+		return false, err
 	}
 
-	rp, err := c.GetScanResults(scanid)
+	return res.Redirection.Pass, nil
+}
+
+// HasHSTSPreload checks whether the site is HSTS preloaded or asks for it
+func (c *Client) HasHSTSPreload(site string) (bool, error) {
+	return c.HasHSTSPreloadContext(context.Background(), site)
+}
+
+// HasHSTSPreloadContext is HasHSTSPreload with a context to cancel the calls
+func (c *Client) HasHSTSPreloadContext(ctx context.Context, site string) (bool, error) {
+	res, err := c.siteResults(ctx, site)
 	if err != nil {
-		return false, errors.Wrap(err, "GetScanResults")
+		return false, err
 	}
 
-	var res Result
+	ok, err := res.HasHSTSPreload()
+	return ok, errors.Wrap(err, "HasHSTSPreload")
+}
 
-	err = json.Unmarshal(rp, &res)
+// InsecureCookies returns the names of the cookies set without the Secure flag
+func (c *Client) InsecureCookies(site string) ([]string, error) {
+	return c.InsecureCookiesContext(context.Background(), site)
+}
+
+// InsecureCookiesContext is InsecureCookies with a context to cancel the calls
+func (c *Client) InsecureCookiesContext(ctx context.Context, site string) ([]string, error) {
+	res, err := c.siteResults(ctx, site)
 	if err != nil {
-		return false, errors.Wrap(err, "unmarshal")
+		return nil, err
 	}
 
-	return res.Redirection.Pass, nil
+	names, err := res.InsecureCookies()
+	return names, errors.Wrap(err, "InsecureCookies")
 }
 
 // Version returns guess what?
@@ -2,12 +2,13 @@ package observatory
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"strings"
 	"time"
 
 	"github.com/pkg/errors"
@@ -37,162 +38,404 @@ func AddQueryParameters(baseURL string, queryParams map[string]string) string {
 	return fmt.Sprintf("%s?%s", baseURL, params.Encode())
 }
 
+// sleepContext waits for d or until ctx is done, whichever comes first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// pollDeadline is when we stop waiting for a scan, zero if there is no limit
+func (c *Client) pollDeadline() time.Time {
+	if c.maxWait <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(c.maxWait)
+}
+
+// pollWait waits before checking the scan again, unless that would go past deadline
+func (c *Client) pollWait(ctx context.Context, deadline time.Time) error {
+	if !deadline.IsZero() && time.Now().Add(c.poll).After(deadline) {
+		return errors.Wrapf(ErrRetriesExceeded, "waited more than %v", c.maxWait)
+	}
+	return sleepContext(ctx, c.poll)
+}
+
 // prepareRequest insert all pre-defined stuff
-func (c *Client) prepareRequest(method, what string, opts map[string]string) (req *http.Request) {
+func (c *Client) prepareRequest(ctx context.Context, method, what string, opts map[string]string) (req *http.Request) {
 	var endPoint string
 
 	endPoint = fmt.Sprintf("%s/%s", c.baseurl, what)
 
-	c.debug("Options:\n%v", opts)
 	baseURL := AddQueryParameters(endPoint, opts)
-	c.debug("baseURL: %s", baseURL)
 
-	req, _ = http.NewRequest(method, baseURL, nil)
+	req, _ = http.NewRequestWithContext(ctx, method, baseURL, nil)
 
-	// We need these when we POST
-	if method == "POST" {
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("Accept", "application/json")
+	// We always want JSON back
+	req.Header.Set("Accept", "application/json")
+	if c.agent != "" {
+		req.Header.Set("User-Agent", c.agent)
 	}
 
 	return
 }
 
-// callAPI is the main API call — straightforward, clean logic
-func (c *Client) callAPI(word, cmd, sbody string, opts map[string]string) ([]byte, error) {
-	c.debug("callAPI")
-	req := c.prepareRequest(word, cmd, opts)
-	if req == nil {
-		return []byte{}, errors.New("req is nil")
+// encodeBody encodes the request body according to its type: url.Values are
+// sent as a form, everything else as JSON.
+func encodeBody(body interface{}) ([]byte, string, error) {
+	switch b := body.(type) {
+	case url.Values:
+		return []byte(b.Encode()), "application/x-www-form-urlencoded", nil
+	default:
+		buf, err := json.Marshal(b)
+		return buf, "application/json", err
+	}
+}
+
+// callAPI is the main API call, transient failures are retried according to the policy
+func (c *Client) callAPI(ctx context.Context, word, cmd string, payload interface{}, opts map[string]string) ([]byte, error) {
+	var (
+		buf   []byte
+		ctype string
+		err   error
+	)
+
+	// If we have a body, encode it once for all attempts.
+	if payload != nil {
+		buf, ctype, err = encodeBody(payload)
+		if err != nil {
+			return []byte{}, errors.Wrap(err, "encode body")
+		}
 	}
 
-	c.debug("clt=%#v", c.client)
-	c.debug("opts=%v", opts)
+	// Common fields of the request events
+	fields := []interface{}{"method", word, "endpoint", cmd}
+	if host := opts["host"]; host != "" {
+		fields = append(fields, "host", host)
+	}
 
-	// If we have a POST and a body, insert them.
-	if sbody != "" && word == "POST" {
-		buf := bytes.NewBufferString(sbody)
-		req.Body = ioutil.NopCloser(buf)
-		req.ContentLength = int64(buf.Len())
+	for retry := 0; ; retry++ {
+		body, resp, err := c.doAPI(ctx, word, cmd, buf, ctype, opts, append(fields, "retry", retry))
+
+		delay, ok := c.retryDelay(ctx, word, retry+1, resp, err)
+		if !ok {
+			return body, err
+		}
+
+		c.logger.Info("retrying", append(fields, "retry", retry+1, "delay", delay, "error", err)...)
+		if err := sleepContext(ctx, delay); err != nil {
+			return body, errors.Wrap(err, "retry")
+		}
 	}
+}
 
-	c.debug("req=%#v body=%v", req, req.Body)
+// doAPI makes one attempt, resp is nil if there is no complete answer
+func (c *Client) doAPI(ctx context.Context, word, cmd string, buf []byte, ctype string, opts map[string]string, fields []interface{}) ([]byte, *http.Response, error) {
+	if err := c.limiter.wait(ctx); err != nil {
+		return []byte{}, nil, errors.Wrap(err, "rate limit")
+	}
 
+	req := c.prepareRequest(ctx, word, cmd, opts)
+	if req == nil {
+		return []byte{}, nil, errors.New("req is nil")
+	}
+
+	// If we have a body, insert it.
+	if buf != nil {
+		req.Body = ioutil.NopCloser(bytes.NewReader(buf))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(buf)), nil
+		}
+		req.ContentLength = int64(len(buf))
+		req.Header.Set("Content-Type", ctype)
+	}
+
+	c.logger.Debug("request", append(fields, "url", req.URL.String(), "headers", redactHeaders(req.Header))...)
+
+	start := time.Now()
 	resp, err := c.client.Do(req)
 	if err != nil {
-		c.debug("err=%#v", err)
-		return []byte{}, errors.Wrap(err, "1st call")
+		c.logger.Error("request failed", append(fields, "duration", time.Since(start), "error", err)...)
+		return []byte{}, nil, errors.Wrap(err, "1st call")
 	}
 	defer resp.Body.Close()
 
-	c.debug("resp=%#v", resp)
-
-	c.debug("read body")
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return []byte{}, errors.Wrap(err, "body read")
+		c.logger.Error("body read failed", append(fields, "status", resp.StatusCode, "error", err)...)
+		return []byte{}, nil, errors.Wrap(err, "body read")
 	}
 
-	c.debug("body=%v", string(body))
-
-	if resp.StatusCode == http.StatusOK {
-
-		c.debug("status OK")
+	c.logger.Debug("response", append(fields, "status", resp.StatusCode,
+		"duration", time.Since(start), "size", len(body))...)
 
-		if strings.Contains(string(body), "error:") {
-			return body, errors.New("error")
+	if resp.StatusCode != http.StatusOK {
+		return body, resp, &HTTPError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Body:       body,
+			Err:        apiError(body),
 		}
-	} else {
-		return body, errors.Wrapf(err, "status: %v body: %q", resp.Status, body)
 	}
-	return body, err
+
+	// Errors are reported in the "error" field
+	return body, resp, apiError(body)
 }
 
-func isValid(ar *Analyze) bool {
+// isValid checks whether the analysis ended less than ttl ago
+func isValid(ar *Analyze, ttl time.Duration) bool {
 	if ar == nil {
 		return false
 	}
 
 	now := time.Now()
 	last, _ := time.Parse(time.RFC1123, ar.EndTime)
-	last = last.Add(10 * time.Minute)
+	last = last.Add(ttl)
 	return last.After(now)
 }
 
-// getAnalyze is an helper func for the API — where the loop/waiting appears
-func (c *Client) getAnalyze(site string, force bool) (*Analyze, error) {
-	var (
-		raw []byte
-		ar  Analyze
-	)
+// scanOptions returns the options given to a public call or the default ones
+func scanOptions(opts []ScanOptions) *ScanOptions {
+	o := DefaultScanOptions
+	if len(opts) != 0 {
+		o = opts[0]
+	}
+	return &o
+}
+
+// values returns the submission parameters, sent as a form
+func (o ScanOptions) values() url.Values {
+	params := url.Values{}
+	if o.Hidden {
+		params.Set("hidden", "true")
+	}
+	if o.Rescan {
+		params.Set("rescan", "true")
+	}
+	return params
+}
 
+// getAnalyze is an helper func for the API — where the loop/waiting appears.
+// If sopts is nil, no scan is submitted and we only look at the current one.
+// Concurrent calls for the same site and options share the same analysis.
+func (c *Client) getAnalyze(ctx context.Context, site string, sopts *ScanOptions) (*Analyze, error) {
 	if site == "" {
-		return &Analyze{}, fmt.Errorf("empty site")
+		return &Analyze{}, ErrEmptySite
+	}
+
+	// Cached value is usable?
+	if ar, ok := c.cache.get(site); ok {
+		c.logger.Debug("cache hit", "host", site)
+		return ar, nil
+	}
+
+	ar, shared, err := c.flights.do(ctx, flightKey(site, sopts), func(fctx context.Context) (*Analyze, error) {
+		return c.runAnalyze(fctx, site, sopts)
+	})
+	if shared {
+		c.logger.Debug("shared analysis", "host", site)
+	}
+	return ar, err
+}
+
+// runAnalyze does the real work for getAnalyze
+func (c *Client) runAnalyze(ctx context.Context, site string, sopts *ScanOptions) (ar *Analyze, err error) {
+	start := time.Now()
+
+	// Failures not coming from the scan itself
+	defer func() {
+		if err != nil && !ar.State.Done() {
+			c.report(site, StageFailed, ar, 0, start, err)
+		}
+	}()
+
+	// v2 scans are synchronous, no need to wait
+	if c.api == 2 {
+		ar, err = c.getAnalyzeV2(ctx, site, sopts)
+		if ar.State.Done() {
+			c.report(site, stageOf(ar.State), ar, 0, start, err)
+		}
+		return ar, err
 	}
 
+	if sopts != nil {
+		ar, final, err := c.submitScan(ctx, site, sopts, start)
+		if err != nil || final || sopts.NoWait {
+			return ar, err
+		}
+	}
+	return c.waitScan(ctx, site, start)
+}
+
+// submitScan asks for a scan of site and returns the first answer.  final is true
+// if there is nothing to wait for, the latest scan being used during the cooldown.
+func (c *Client) submitScan(ctx context.Context, site string, sopts *ScanOptions, start time.Time) (*Analyze, bool, error) {
+	var ar Analyze
+
 	opts := map[string]string{
 		"host": site,
 	}
 
-	if force {
-		body := "hidden=true&rescan=true"
-		ret, err := c.callAPI("POST", "analyze", body, opts)
-		if err != nil || strings.Contains(string(ret), `"error":`) {
-			c.debug("post/1st call")
-			return &Analyze{}, errors.Wrapf(err, "post/Analyze: %s", string(ret))
+	// No need to ask, the API would refuse the rescan
+	if sopts.Rescan && c.cooldowns.remaining(site) > 0 {
+		if c.cooldownPolicy == CooldownFallback {
+			return c.fallback(ctx, site, start)
+		}
+		if err := c.waitCooldown(ctx, site); err != nil {
+			return &Analyze{}, false, err
+		}
+	}
+
+	ret, err := c.callAPI(ctx, "POST", "analyze", sopts.values(), opts)
+	if errors.Is(err, ErrRescanTooSoon) {
+		// Someone else did rescan it
+		c.cooldowns.start(site)
+		if c.cooldownPolicy == CooldownFallback {
+			return c.fallback(ctx, site, start)
+		}
+		if err := c.waitCooldown(ctx, site); err != nil {
+			return &Analyze{}, false, err
 		}
+		ret, err = c.callAPI(ctx, "POST", "analyze", sopts.values(), opts)
+	}
+	if err != nil {
+		return &Analyze{}, false, errors.Wrapf(err, "post/Analyze: %s", string(ret))
 	}
 
-	retry := 0
+	if sopts.Rescan {
+		c.cooldowns.start(site)
+	}
 
-	// Cached value is usable?
-	if isValid(c.last) {
-		return c.last, nil
+	c.logger.Info("scan submitted", "host", site, "hidden", sopts.Hidden, "rescan", sopts.Rescan)
+
+	err = json.Unmarshal(ret, &ar)
+	if err == nil {
+		c.report(site, StageSubmitted, &ar, 0, start, nil)
+	}
+	if ar.State == StateFinished {
+		c.cache.put(site, &ar)
 	}
+	return &ar, false, errors.Wrap(err, "unmarshall")
+}
+
+// fallback returns the latest finished scan instead of the rescan, along with an
+// error matching ErrRescanTooSoon as it might be much older than expected
+func (c *Client) fallback(ctx context.Context, site string, start time.Time) (*Analyze, bool, error) {
+	c.logger.Info("rescan too soon, using latest scan", "host", site, "cooldown", c.cooldowns.remaining(site))
 
+	lar, err := c.GetLatestAnalysisContext(ctx, site)
+	if err != nil {
+		return lar, true, errors.Wrap(err, "fallback")
+	}
+
+	err = errors.Wrapf(ErrRescanTooSoon, "using scan %d ended %s", lar.ScanID, lar.EndTime)
+	c.report(site, StageFailed, lar, 0, start, err)
+	return lar, true, err
+}
+
+// waitCooldown waits until site can be rescanned
+func (c *Client) waitCooldown(ctx context.Context, site string) error {
+	wait := c.cooldowns.remaining(site)
+
+	c.logger.Info("rescan too soon, waiting", "host", site, "cooldown", wait)
+	return errors.Wrap(sleepContext(ctx, wait), "cooldown")
+}
+
+// waitScan polls the current scan of site until it is done, start is for the progress events
+func (c *Client) waitScan(ctx context.Context, site string, start time.Time) (*Analyze, error) {
 	// WAIT/RETRY loop is only for Analyse.
-	for {
+	deadline := c.pollDeadline()
+	for retry := 0; ; {
 		if retry >= c.retries {
-			c.debug("too many retries")
-			return &Analyze{}, fmt.Errorf("retries exceeded - raw=%v", raw)
+			c.logger.Info("too many retries", "host", site, "retry", retry)
+			return &Analyze{}, errors.Wrapf(ErrRetriesExceeded, "after %d tries", retry)
 		}
 
-		raw, err := c.callAPI("GET", "analyze", "", opts)
+		ar, err := c.fetchAnalyze(ctx, site)
 		if err != nil {
-			c.debug("get/analyse")
-			return &ar, errors.Wrapf(err, "get/Analyze: %v", raw)
+			// A failed scan usually comes with an error
+			if ar.State.Done() {
+				c.report(site, StageFailed, ar, retry, start, err)
+			}
+			return ar, err
 		}
 
-		if strings.Contains(string(raw), `state":"PENDING"`) {
-			c.debug("PENDING retry=%d", retry)
-			time.Sleep(2 * time.Second)
-			retry++
-			continue
+		if done, err := c.checkState(site, ar, retry, start); done {
+			return ar, err
 		}
 
-		if strings.Contains(string(raw), `"state":"FAILED"`) {
-			c.debug("FAILED retry=%d", retry)
-			c.debug("raw/analyse=%s", string(raw))
+		if err := c.pollWait(ctx, deadline); err != nil {
+			return ar, errors.Wrap(err, "wait/Analyze")
+		}
+		retry++
+	}
+}
 
+// fetchAnalyze gets the current scan of site without submitting anything
+func (c *Client) fetchAnalyze(ctx context.Context, site string) (*Analyze, error) {
+	var ar Analyze
+
+	opts := map[string]string{
+		"host": site,
+	}
+
+	raw, err := c.callAPI(ctx, "GET", "analyze", nil, opts)
+	if err != nil {
+		// The answer is still a scan, keep it for the caller
+		var ae *APIError
+		if errors.As(err, &ae) {
 			_ = json.Unmarshal(raw, &ar)
-			return &ar, errors.New("site analysis failed")
 		}
+		return &ar, errors.Wrap(err, "get/Analyze")
+	}
 
-		if strings.Contains(string(raw), `state":"FINISHED"`) {
-			c.debug("FINISHED retry=%d", retry)
-			c.debug("raw/analyse=%s", string(raw))
+	if err := json.Unmarshal(raw, &ar); err != nil {
+		return &ar, errors.Wrap(err, "unmarshall")
+	}
+	return &ar, nil
+}
 
-			err := json.Unmarshal(raw, &ar)
-			// Store the last call
-			c.last = &ar
-			return &ar, errors.Wrap(err, "unmarshall")
-		}
-		if strings.Contains(string(raw), `"error":`) {
-			c.debug("ERROR")
-			c.debug("raw/analyse=%s", string(raw))
+// checkState looks at the state of the scan, done is false if it is still running
+func (c *Client) checkState(site string, ar *Analyze, retry int, start time.Time) (done bool, err error) {
+	switch ar.State {
+	case StateFinished:
+		c.logger.Info("scan finished", "host", site, "scan_id", ar.ScanID, "grade", ar.Grade, "retry", retry)
+
+		// Store the last call
+		c.cache.put(site, ar)
+		c.report(site, StageFinished, ar, retry, start, nil)
+		return true, nil
+
+	case StateFailed, StateAborted:
+		c.logger.Info("scan failed", "host", site, "scan_id", ar.ScanID, "state", ar.State, "retry", retry)
+		err := errors.Wrapf(ErrScanFailed, "state %s", ar.State)
+		c.report(site, StageFailed, ar, retry, start, err)
+		return true, err
+
+	case StatePending, StateStarting, StateRunning:
+		c.logger.Debug("scan not finished", "host", site, "state", ar.State, "retry", retry)
+		c.report(site, stageOf(ar.State), ar, retry, start, nil)
+		return false, nil
+
+	default:
+		c.logger.Error("unknown state", "host", site, "state", ar.State)
+		return true, errors.Wrapf(ErrUnknownState, "%q", ar.State)
+	}
+}
 
-			return &ar, errors.Errorf("returned: %v", string(raw))
-		}
-		c.debug("loop retry=%d", retry)
+// siteResults fetches the test results of the most recent scan of site
+func (c *Client) siteResults(ctx context.Context, site string) (*Result, error) {
+	scanid, err := c.GetScanIDContext(ctx, site)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetScanID")
 	}
+
+	res, err := c.GetTestResultsContext(ctx, scanid)
+	return res, errors.Wrap(err, "GetTestResults")
 }
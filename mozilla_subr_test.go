@@ -1,14 +1,18 @@
 package observatory
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"testing"
 	"time"
 
 	"github.com/h2non/gock"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -66,7 +70,7 @@ func TestPrepareRequest(t *testing.T) {
 	assert.Equal(t, testURL, c.baseurl)
 
 	var opts = map[string]string{}
-	req := c.prepareRequest("GET", "foo", opts)
+	req := c.prepareRequest(context.Background(), "GET", "foo", opts)
 
 	assert.IsType(t, (*http.Request)(nil), req)
 	assert.Equal(t, u.Host, req.Host)
@@ -80,7 +84,7 @@ func TestPrepareRequest_2(t *testing.T) {
 	assert.Equal(t, baseURL, c.baseurl)
 
 	var opts = map[string]string{}
-	req := c.prepareRequest("GET", "foo", opts)
+	req := c.prepareRequest(context.Background(), "GET", "foo", opts)
 
 	assert.IsType(t, (*http.Request)(nil), req)
 	assert.Equal(t, u.Host, req.Host)
@@ -94,7 +98,7 @@ func TestPrepareRequest_3(t *testing.T) {
 	assert.Equal(t, testURL, c.baseurl)
 
 	var opts = map[string]string{}
-	req := c.prepareRequest("GET", "foo", opts)
+	req := c.prepareRequest(context.Background(), "GET", "foo", opts)
 
 	assert.IsType(t, (*http.Request)(nil), req)
 	assert.Equal(t, u.Host, req.Host)
@@ -106,12 +110,12 @@ func TestPrepareRequest_4(t *testing.T) {
 	u, _ := url.Parse(testURL)
 
 	var opts = map[string]string{}
-	req := c.prepareRequest("POST", "foo", opts)
+	req := c.prepareRequest(context.Background(), "POST", "foo", opts)
 
 	assert.IsType(t, (*http.Request)(nil), req)
 	assert.Equal(t, u.Host, req.Host)
 	assert.Equal(t, "POST", req.Method)
-	assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+	assert.Equal(t, "", req.Header.Get("Content-Type"))
 	assert.Equal(t, "application/json", req.Header.Get("Accept"))
 }
 
@@ -126,7 +130,7 @@ func TestClient_CallAPI(t *testing.T) {
 		Post("analyze").
 		MatchParam("host", site).
 		MatchHeaders(map[string]string{
-			"content-type": "application/json",
+			"content-type": "application/x-www-form-urlencoded",
 			"accept":       "application/json",
 		}).
 		BodyString("hidden=true").
@@ -144,11 +148,47 @@ func TestClient_CallAPI(t *testing.T) {
 		"host": site,
 	}
 
-	body := "hidden=true"
-	ret, err := c.callAPI("POST", "analyze", body, opts)
+	body := url.Values{"hidden": {"true"}}
+	ret, err := c.callAPI(context.Background(), "POST", "analyze", body, opts)
 
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, ErrRecentScanNotFound))
+	assert.Equal(t, ftr, string(ret))
+}
+
+func TestClient_CallAPI_Status(t *testing.T) {
+	defer gock.Off()
+
+	site := "www.ssllabs.com"
+
+	ftr := `{"error":"invalid-hostname-lookup","text":"www.ssllabs.com can not be resolved"}`
+
+	gock.New(baseURL).
+		Get("analyze").
+		MatchParam("host", site).
+		Reply(400).
+		BodyString(ftr)
+
+	c, err := NewClient(Config{Timeout: 10})
 	assert.NoError(t, err)
+
+	gock.InterceptClient(c.client)
+	defer gock.RestoreClient(c.client)
+
+	opts := map[string]string{
+		"host": site,
+	}
+
+	ret, err := c.callAPI(context.Background(), "GET", "analyze", nil, opts)
+	assert.Error(t, err)
 	assert.Equal(t, ftr, string(ret))
+
+	var he *HTTPError
+
+	require.True(t, errors.As(err, &he))
+	assert.Equal(t, 400, he.StatusCode)
+	assert.Equal(t, ftr, string(he.Body))
+	assert.True(t, errors.Is(err, ErrInvalidHostname))
 }
 
 func TestClient_CallAPI2(t *testing.T) {
@@ -163,7 +203,7 @@ func TestClient_CallAPI2(t *testing.T) {
 		Post("analyze").
 		MatchParam("host", site).
 		MatchHeaders(map[string]string{
-			"content-type": "application/json",
+			"content-type": "application/x-www-form-urlencoded",
 			"accept":       "application/json",
 		}).
 		BodyString("hidden=true&rescan=true").
@@ -181,8 +221,8 @@ func TestClient_CallAPI2(t *testing.T) {
 		"host": site,
 	}
 
-	body := "hidden=true&rescan=true"
-	ret, err := c.callAPI("POST", "analyze", body, opts)
+	body := url.Values{"hidden": {"true"}, "rescan": {"true"}}
+	ret, err := c.callAPI(context.Background(), "POST", "analyze", body, opts)
 
 	assert.NoError(t, err)
 	assert.Equal(t, ftr, ret)
@@ -213,7 +253,7 @@ func TestClient_CallAPI3(t *testing.T) {
 		"host": site,
 	}
 
-	ret, err := c.callAPI("GET", "analyze", "", opts)
+	ret, err := c.callAPI(context.Background(), "GET", "analyze", nil, opts)
 
 	assert.NoError(t, err)
 	assert.Equal(t, ftr, ret)
@@ -234,7 +274,7 @@ func TestClient_GetAnalyse(t *testing.T) {
 		Post("analyze").
 		MatchParam("host", site).
 		MatchHeaders(map[string]string{
-			"content-type": "application/json",
+			"content-type": "application/x-www-form-urlencoded",
 			"accept":       "application/json",
 		}).
 		BodyString("hidden=true&rescan=true").
@@ -259,7 +299,7 @@ func TestClient_GetAnalyse(t *testing.T) {
 	err = json.Unmarshal(ftc, &report)
 	require.NoError(t, err)
 
-	ret, err := c.getAnalyze(site, true)
+	ret, err := c.getAnalyze(context.Background(), site, &DefaultScanOptions)
 	assert.NoError(t, err)
 	assert.EqualValues(t, &report, ret)
 }
@@ -291,7 +331,7 @@ func TestClient_GetAnalyse2(t *testing.T) {
 	err = json.Unmarshal(ftc, &report)
 	require.NoError(t, err)
 
-	raw, err := c.getAnalyze(site, false)
+	raw, err := c.getAnalyze(context.Background(), site, nil)
 	assert.Error(t, err)
 	t.Logf("error=%v raw=%v", err, raw)
 }
@@ -311,7 +351,7 @@ func TestClient_GetAnalyse_Error(t *testing.T) {
 		Post("analyze").
 		MatchParam("host", site).
 		MatchHeaders(map[string]string{
-			"content-type": "application/json",
+			"content-type": "application/x-www-form-urlencoded",
 			"accept":       "application/json",
 		}).
 		BodyString("hidden=true&rescan=true").
@@ -336,8 +376,10 @@ func TestClient_GetAnalyse_Error(t *testing.T) {
 	err = json.Unmarshal(ftc, &report)
 	require.NoError(t, err)
 
-	ret, err := c.getAnalyze(site, true)
+	ret, err := c.getAnalyze(context.Background(), site, &DefaultScanOptions)
 	assert.Error(t, err)
+	assert.True(t, errors.Is(err, ErrSiteDown))
+	assert.True(t, errors.Is(err, ErrScanFailed))
 	assert.EqualValues(t, &report, ret)
 }
 
@@ -350,8 +392,9 @@ func TestClient_GetAnalyseEmpty(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, baseURL, c.baseurl)
 
-	_, err = c.getAnalyze(site, false)
+	_, err = c.getAnalyze(context.Background(), site, nil)
 	assert.Error(t, err)
+	assert.Equal(t, ErrEmptySite, err)
 	assert.Equal(t, "empty site", err.Error())
 }
 
@@ -360,17 +403,294 @@ func TestClient_GetScanReport2(t *testing.T) {
 }
 
 func TestIsValid_Nil(t *testing.T) {
-	require.False(t, isValid(nil))
+	require.False(t, isValid(nil, DefaultCacheTTL))
 }
 
 func TestIsValid_Old(t *testing.T) {
 	now := time.Now().Add(-1 * time.Minute)
 	ar := &Analyze{EndTime: now.Format(time.RFC1123)}
-	require.True(t, isValid(ar))
+	require.True(t, isValid(ar, DefaultCacheTTL))
 }
 
 func TestIsValid_New(t *testing.T) {
 	now := time.Now().Add(-20 * time.Minute)
 	ar := &Analyze{EndTime: now.Format(time.RFC1123)}
-	require.False(t, isValid(ar))
+	require.False(t, isValid(ar, DefaultCacheTTL))
+}
+
+func TestSleepContext(t *testing.T) {
+	err := sleepContext(context.Background(), time.Millisecond)
+	assert.NoError(t, err)
+}
+
+func TestSleepContext_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := sleepContext(ctx, time.Minute)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestClient_GetAnalyse_Cancel(t *testing.T) {
+	defer gock.Off()
+
+	site := "www.ssllabs.com"
+
+	ftc, err := ioutil.ReadFile("testdata/ssllabs-post.json")
+	assert.NoError(t, err)
+
+	gock.New(baseURL).
+		Get("analyze").
+		MatchParam("host", site).
+		Persist().
+		Reply(200).
+		BodyString(string(ftc))
+
+	c, err := NewClient(Config{Timeout: 10})
+	assert.NoError(t, err)
+
+	// Would wait forever without the context
+	c.poll = time.Hour
+
+	gock.InterceptClient(c.client)
+	defer gock.RestoreClient(c.client)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = c.getAnalyze(ctx, site, nil)
+	assert.Error(t, err)
+	assert.Equal(t, context.DeadlineExceeded, errors.Cause(err))
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestScanState_Done(t *testing.T) {
+	testData := []struct {
+		State ScanState
+		Done  bool
+	}{
+		{StateAborted, true},
+		{StateFailed, true},
+		{StateFinished, true},
+		{StatePending, false},
+		{StateRunning, false},
+		{StateStarting, false},
+		{ScanState("FOO"), false},
+	}
+
+	for _, td := range testData {
+		assert.Equal(t, td.Done, td.State.Done(), string(td.State))
+	}
+}
+
+func TestClient_GetAnalyse_States(t *testing.T) {
+	site := "www.ssllabs.com"
+
+	testData := []struct {
+		States []ScanState
+		Final  ScanState
+		Err    error
+	}{
+		{[]ScanState{StateFinished}, StateFinished, nil},
+		{[]ScanState{StatePending, StateFinished}, StateFinished, nil},
+		{[]ScanState{StateStarting, StateRunning, StateFinished}, StateFinished, nil},
+		{[]ScanState{StatePending, StateStarting, StateRunning, StateFinished}, StateFinished, nil},
+		{[]ScanState{StateRunning, StateFailed}, StateFailed, ErrScanFailed},
+		{[]ScanState{StatePending, StateAborted}, StateAborted, ErrScanFailed},
+		{[]ScanState{StatePending, "FOO"}, "FOO", ErrUnknownState},
+		{[]ScanState{StatePending, StatePending, StateStarting, StateRunning, StateRunning}, "", ErrRetriesExceeded},
+	}
+
+	for _, td := range testData {
+		for i, st := range td.States {
+			gock.New(baseURL).
+				Get("analyze").
+				MatchParam("host", site).
+				Reply(200).
+				BodyString(fmt.Sprintf(`{"scan_id":%d,"state":%q,"grade":"A"}`, i+1, st))
+		}
+
+		c, err := NewClient(Config{Timeout: 10})
+		require.NoError(t, err)
+
+		c.poll = time.Millisecond
+		gock.InterceptClient(c.client)
+
+		ar, err := c.getAnalyze(context.Background(), site, nil)
+		if td.Err == nil {
+			assert.NoError(t, err)
+		} else {
+			assert.True(t, errors.Is(err, td.Err), "%v: %v", td.States, err)
+		}
+		assert.Equal(t, td.Final, ar.State)
+		assert.True(t, gock.IsDone(), "%v", td.States)
+
+		gock.RestoreClient(c.client)
+		gock.Off()
+	}
+}
+
+func TestScanOptions_Body(t *testing.T) {
+	testData := []struct {
+		Opts ScanOptions
+		Body string
+	}{
+		{ScanOptions{}, ""},
+		{ScanOptions{Hidden: true}, "hidden=true"},
+		{ScanOptions{Rescan: true}, "rescan=true"},
+		{ScanOptions{Hidden: true, Rescan: true, NoWait: true}, "hidden=true&rescan=true"},
+		{DefaultScanOptions, "hidden=true&rescan=true"},
+	}
+
+	for _, td := range testData {
+		assert.Equal(t, td.Body, td.Opts.values().Encode())
+	}
+}
+
+func TestScanOptions_Default(t *testing.T) {
+	assert.Equal(t, DefaultScanOptions, *scanOptions(nil))
+
+	o := ScanOptions{Rescan: true}
+	assert.Equal(t, o, *scanOptions([]ScanOptions{o}))
+}
+
+func TestClient_GetAnalyse_NoWait(t *testing.T) {
+	defer gock.Off()
+
+	site := "www.ssllabs.com"
+
+	ftr, err := ioutil.ReadFile("testdata/ssllabs-post.json")
+	assert.NoError(t, err)
+
+	gock.New(baseURL).
+		Post("analyze").
+		MatchParam("host", site).
+		BodyString("rescan=true").
+		Reply(200).
+		BodyString(string(ftr))
+
+	c, err := NewClient(Config{Timeout: 10})
+	assert.NoError(t, err)
+
+	gock.InterceptClient(c.client)
+	defer gock.RestoreClient(c.client)
+
+	ar, err := c.getAnalyze(context.Background(), site, &ScanOptions{Rescan: true, NoWait: true})
+	assert.NoError(t, err)
+	assert.Equal(t, StatePending, ar.State)
+	assert.Equal(t, 8507653, ar.ScanID)
+	assert.True(t, gock.IsDone())
+	assert.Equal(t, 0, c.CacheStats().Size)
+}
+
+func TestEncodeBody(t *testing.T) {
+	buf, ctype, err := encodeBody(url.Values{"rescan": {"true"}, "hidden": {"true"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "application/x-www-form-urlencoded", ctype)
+	assert.Equal(t, "hidden=true&rescan=true", string(buf))
+
+	buf, ctype, err = encodeBody(map[string]int{"scan": 42})
+	assert.NoError(t, err)
+	assert.Equal(t, "application/json", ctype)
+	assert.Equal(t, `{"scan":42}`, string(buf))
+
+	_, _, err = encodeBody(make(chan int))
+	assert.Error(t, err)
+}
+
+// wire is what the fake server received
+type wire struct {
+	Method string
+	Query  url.Values
+	Header http.Header
+	Body   string
+}
+
+// wireServer records what it receives and answers a FINISHED scan
+func wireServer(got *wire) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		*got = wire{
+			Method: r.Method,
+			Query:  r.URL.Query(),
+			Header: r.Header,
+			Body:   string(b),
+		}
+		fmt.Fprint(w, `{"scan_id":1,"state":"FINISHED"}`)
+	}))
+}
+
+func TestClient_CallAPI_Form(t *testing.T) {
+	var got wire
+
+	srv := wireServer(&got)
+	defer srv.Close()
+
+	c := Before(t, srv.URL)
+
+	opts := map[string]string{"host": "example.com"}
+	body := url.Values{"hidden": {"true"}, "rescan": {"true"}}
+
+	_, err := c.callAPI(context.Background(), "POST", "analyze", body, opts)
+	require.NoError(t, err)
+
+	assert.Equal(t, "POST", got.Method)
+	assert.Equal(t, "example.com", got.Query.Get("host"))
+	assert.Equal(t, "application/x-www-form-urlencoded", got.Header.Get("Content-Type"))
+	assert.Equal(t, "application/json", got.Header.Get("Accept"))
+	assert.Equal(t, "23", got.Header.Get("Content-Length"))
+	assert.Equal(t, "hidden=true&rescan=true", got.Body)
+}
+
+func TestClient_CallAPI_JSON(t *testing.T) {
+	var got wire
+
+	srv := wireServer(&got)
+	defer srv.Close()
+
+	c := Before(t, srv.URL)
+
+	body := struct {
+		Target string `json:"target"`
+	}{"example.com"}
+
+	_, err := c.callAPI(context.Background(), "POST", "scan", body, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, "POST", got.Method)
+	assert.Equal(t, "application/json", got.Header.Get("Content-Type"))
+	assert.Equal(t, `{"target":"example.com"}`, got.Body)
+}
+
+func TestClient_CallAPI_NoBody(t *testing.T) {
+	var got wire
+
+	srv := wireServer(&got)
+	defer srv.Close()
+
+	c := Before(t, srv.URL)
+
+	_, err := c.callAPI(context.Background(), "GET", "analyze", nil, map[string]string{"host": "example.com"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "GET", got.Method)
+	assert.Equal(t, "", got.Header.Get("Content-Type"))
+	assert.Equal(t, "", got.Body)
+}
+
+func TestClient_GetAnalyse_Wire(t *testing.T) {
+	var got wire
+
+	srv := wireServer(&got)
+	defer srv.Close()
+
+	c := Before(t, srv.URL)
+
+	_, err := c.getAnalyze(context.Background(), "example.com", &ScanOptions{Hidden: true, NoWait: true})
+	require.NoError(t, err)
+
+	assert.Equal(t, "POST", got.Method)
+	assert.Equal(t, "application/x-www-form-urlencoded", got.Header.Get("Content-Type"))
+	assert.Equal(t, "hidden=true", got.Body)
 }
@@ -1,11 +1,19 @@
 package observatory
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/h2non/gock"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -31,6 +39,54 @@ func TestNewClient(t *testing.T) {
 	assert.NotNil(t, c.client)
 }
 
+// countingTransport counts the requests going through it
+type countingTransport struct {
+	n    int32
+	next http.RoundTripper
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&t.n, 1)
+	return t.next.RoundTrip(req)
+}
+
+func TestNewClient_HTTPClient(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"scan_id":1,"state":"FINISHED","grade":"A","end_time":"Mon, 12 Aug 2024 08:20:18 GMT"}`)
+	}))
+	defer srv.Close()
+
+	hc := srv.Client()
+
+	c, err := NewClient(Config{BaseURL: srv.URL, HTTPClient: hc})
+	require.NoError(t, err)
+	assert.Equal(t, hc, c.client)
+
+	grade, err := c.GetGrade("www.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "A", grade)
+}
+
+func TestNewClient_Transport(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"scan_id":1,"state":"FINISHED","grade":"B","end_time":"Mon, 12 Aug 2024 08:20:18 GMT"}`)
+	}))
+	defer srv.Close()
+
+	trsp := &countingTransport{next: srv.Client().Transport}
+
+	c, err := NewClient(Config{BaseURL: srv.URL, Transport: trsp, Timeout: 5})
+	require.NoError(t, err)
+	assert.Equal(t, trsp, c.client.Transport)
+	assert.Equal(t, 5*time.Second, c.client.Timeout)
+	assert.NotNil(t, c.client.CheckRedirect)
+
+	grade, err := c.GetGrade("www.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "B", grade)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&trsp.n))
+}
+
 func TestClient_GetHostHistory(t *testing.T) {
 	c, err := NewClient()
 	assert.NoError(t, err)
@@ -94,7 +150,7 @@ func TestClient_GetGrade(t *testing.T) {
 		Post("analyze").
 		MatchParam("host", site).
 		MatchHeaders(map[string]string{
-			"content-type": "application/json",
+			"content-type": "application/x-www-form-urlencoded",
 			"accept":       "application/json",
 		}).
 		BodyString("hidden=true&rescan=true").
@@ -134,7 +190,7 @@ func TestClient_GetScore(t *testing.T) {
 		Post("analyze").
 		MatchParam("host", site).
 		MatchHeaders(map[string]string{
-			"content-type": "application/json",
+			"content-type": "application/x-www-form-urlencoded",
 			"accept":       "application/json",
 		}).
 		BodyString("hidden=true&rescan=true").
@@ -270,3 +326,350 @@ func TestClient_IsHTTPSonly(t *testing.T) {
 	assert.NoError(t, err)
 	assert.True(t, test)
 }
+
+func TestClient_GetGradeContext(t *testing.T) {
+	defer gock.Off()
+
+	site := "www.ssllabs.com"
+
+	ftr, err := ioutil.ReadFile("testdata/ssllabs-post.json")
+	assert.NoError(t, err)
+
+	gock.New(baseURL).
+		Post("analyze").
+		MatchParam("host", site).
+		Reply(200).
+		BodyString(string(ftr))
+
+	gock.New(baseURL).
+		Get("analyze").
+		MatchParam("host", site).
+		Persist().
+		Reply(200).
+		BodyString(string(ftr))
+
+	c, err := NewClient(Config{Timeout: 10})
+	assert.NoError(t, err)
+
+	c.poll = time.Hour
+
+	gock.InterceptClient(c.client)
+	defer gock.RestoreClient(c.client)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = c.GetGradeContext(ctx, site)
+	assert.Error(t, err)
+	assert.Equal(t, context.DeadlineExceeded, errors.Cause(err))
+}
+
+func TestClient_GetGrade_Parallel(t *testing.T) {
+	var posts, gets int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.URL.Query().Get("host")
+		if r.Method == "POST" {
+			atomic.AddInt32(&posts, 1)
+			fmt.Fprint(w, `{"scan_id":1,"state":"PENDING"}`)
+			return
+		}
+		atomic.AddInt32(&gets, 1)
+		fmt.Fprintf(w, `{"scan_id":1,"state":"FINISHED","grade":%q,"end_time":%q}`,
+			host, time.Now().UTC().Format(time.RFC1123))
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(Config{BaseURL: srv.URL})
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			site := fmt.Sprintf("site%d.example.com", i%10)
+			grade, err := c.GetGrade(site)
+			assert.NoError(t, err)
+			assert.Equal(t, site, grade)
+		}(i)
+	}
+	wg.Wait()
+
+	st := c.CacheStats()
+	assert.Equal(t, 10, st.Size)
+	assert.Equal(t, 50, st.Hits+st.Misses)
+	assert.EqualValues(t, atomic.LoadInt32(&posts), atomic.LoadInt32(&gets))
+}
+
+func TestClient_GetTestResults(t *testing.T) {
+	defer gock.Off()
+
+	ftc, err := ioutil.ReadFile("testdata/ssllabs-8507653.json")
+	assert.NoError(t, err)
+
+	gock.New(baseURL).
+		Get("getScanResults").
+		MatchParam("scan", "8507653").
+		Reply(200).
+		BodyString(string(ftc))
+
+	c, err := NewClient(Config{Timeout: 10})
+	assert.NoError(t, err)
+
+	gock.InterceptClient(c.client)
+	defer gock.RestoreClient(c.client)
+
+	res, err := c.GetTestResults(8507653)
+	require.NoError(t, err)
+	assert.Equal(t, "content-security-policy", res.ContentSecurityPolicy.Name)
+	assert.Equal(t, "redirection-to-https", res.Redirection.Result)
+	assert.True(t, res.Redirection.Pass)
+	assert.Empty(t, res.Others)
+}
+
+func TestClient_HasHSTSPreload(t *testing.T) {
+	defer gock.Off()
+
+	site := "www.ssllabs.com"
+
+	ftc, err := ioutil.ReadFile("testdata/ssllabs-get.json")
+	assert.NoError(t, err)
+
+	gock.New(baseURL).
+		Get("analyze").
+		MatchParam("host", site).
+		Reply(200).
+		BodyString(string(ftc))
+
+	ftr, err := ioutil.ReadFile("testdata/ssllabs-8507653.json")
+	assert.NoError(t, err)
+
+	gock.New(baseURL).
+		Get("getScanResults").
+		MatchParam("scan", "8507653").
+		Reply(200).
+		BodyString(string(ftr))
+
+	c, err := NewClient(Config{Timeout: 10})
+	assert.NoError(t, err)
+
+	gock.InterceptClient(c.client)
+	defer gock.RestoreClient(c.client)
+
+	ok, err := c.HasHSTSPreload(site)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestClient_InsecureCookies(t *testing.T) {
+	defer gock.Off()
+
+	site := "www.ssllabs.com"
+
+	ftc, err := ioutil.ReadFile("testdata/ssllabs-get.json")
+	assert.NoError(t, err)
+
+	gock.New(baseURL).
+		Get("analyze").
+		MatchParam("host", site).
+		Reply(200).
+		BodyString(string(ftc))
+
+	ftr, err := ioutil.ReadFile("testdata/ssllabs-8507653.json")
+	assert.NoError(t, err)
+
+	gock.New(baseURL).
+		Get("getScanResults").
+		MatchParam("scan", "8507653").
+		Reply(200).
+		BodyString(string(ftr))
+
+	c, err := NewClient(Config{Timeout: 10})
+	assert.NoError(t, err)
+
+	gock.InterceptClient(c.client)
+	defer gock.RestoreClient(c.client)
+
+	names, err := c.InsecureCookies(site)
+	assert.NoError(t, err)
+	assert.Empty(t, names)
+}
+
+func TestClient_GetAnalysis_Public(t *testing.T) {
+	var bodies []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			b, _ := ioutil.ReadAll(r.Body)
+			bodies = append(bodies, string(b))
+			fmt.Fprint(w, `{"scan_id":1,"state":"PENDING"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"scan_id":1,"state":"FINISHED","grade":"B","score":70,"end_time":%q}`,
+			time.Now().UTC().Format(time.RFC1123))
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(Config{BaseURL: srv.URL, CacheTTL: -1})
+	require.NoError(t, err)
+
+	ar, err := c.GetAnalysis("example.com", ScanOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "B", ar.Grade)
+
+	score, err := c.GetScore("example.com", ScanOptions{Hidden: true})
+	require.NoError(t, err)
+	assert.Equal(t, 70, score)
+
+	grade, err := c.GetGrade("example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "B", grade)
+
+	assert.Equal(t, []string{"", "hidden=true", "hidden=true&rescan=true"}, bodies)
+}
+
+func TestClient_GetLatestAnalysis(t *testing.T) {
+	defer gock.Off()
+
+	site := "www.ssllabs.com"
+
+	ftc, err := ioutil.ReadFile("testdata/ssllabs-get.json")
+	assert.NoError(t, err)
+
+	gock.New(baseURL).
+		Get("analyze").
+		MatchParam("host", site).
+		Reply(200).
+		BodyString(string(ftc))
+
+	c, err := NewClient(Config{Timeout: 10})
+	assert.NoError(t, err)
+
+	gock.InterceptClient(c.client)
+	defer gock.RestoreClient(c.client)
+
+	ar, err := c.GetLatestAnalysis(site)
+	require.NoError(t, err)
+	assert.Equal(t, "A+", ar.Grade)
+	assert.Equal(t, 8507653, ar.ScanID)
+	assert.True(t, gock.IsDone())
+}
+
+func TestClient_GetLatestAnalysis_Pending(t *testing.T) {
+	defer gock.Off()
+
+	site := "www.ssllabs.com"
+
+	ftc, err := ioutil.ReadFile("testdata/ssllabs-post.json")
+	assert.NoError(t, err)
+
+	gock.New(baseURL).
+		Get("analyze").
+		MatchParam("host", site).
+		Reply(200).
+		BodyString(string(ftc))
+
+	fth, err := ioutil.ReadFile("testdata/ssllabs-history.json")
+	assert.NoError(t, err)
+
+	gock.New(baseURL).
+		Get("getHostHistory").
+		MatchParam("host", site).
+		Reply(200).
+		BodyString(string(fth))
+
+	c, err := NewClient(Config{Timeout: 10})
+	assert.NoError(t, err)
+
+	gock.InterceptClient(c.client)
+	defer gock.RestoreClient(c.client)
+
+	grade, err := c.GetCachedGrade(site)
+	require.NoError(t, err)
+	assert.Equal(t, "A+", grade)
+	assert.True(t, gock.IsDone())
+}
+
+func TestClient_GetLatestAnalysis_None(t *testing.T) {
+	defer gock.Off()
+
+	site := "www.ssllabs.com"
+
+	gock.New(baseURL).
+		Get("analyze").
+		MatchParam("host", site).
+		Reply(200).
+		BodyString(`{"error":"recent-scan-not-found","text":"Recently completed scan for www.ssllabs.com not found"}`)
+
+	gock.New(baseURL).
+		Get("getHostHistory").
+		MatchParam("host", site).
+		Reply(200).
+		BodyString(`{"error":"No history found"}`)
+
+	c, err := NewClient(Config{Timeout: 10})
+	assert.NoError(t, err)
+
+	gock.InterceptClient(c.client)
+	defer gock.RestoreClient(c.client)
+
+	_, err = c.GetLatestAnalysis(site)
+	assert.Equal(t, ErrNoScanYet, err)
+
+	_, err = c.GetLatestAnalysis("")
+	assert.Equal(t, ErrEmptySite, err)
+}
+
+func TestClient_GetLatestAnalysis_Failed(t *testing.T) {
+	defer gock.Off()
+
+	site := "www.ssllabs.com"
+
+	gock.New(baseURL).
+		Get("analyze").
+		MatchParam("host", site).
+		Reply(200).
+		BodyString(`{"scan_id":2,"state":"FAILED","error":"site down"}`)
+
+	gock.New(baseURL).
+		Get("getHostHistory").
+		MatchParam("host", site).
+		Reply(200).
+		BodyString(`[{"end_time":"Fri, 22 Jul 2016 00:48:42 GMT","grade":"B","scan_id":1,"score":75}]`)
+
+	c, err := NewClient(Config{Timeout: 10})
+	assert.NoError(t, err)
+
+	gock.InterceptClient(c.client)
+	defer gock.RestoreClient(c.client)
+
+	ar, err := c.GetLatestAnalysis(site)
+	require.NoError(t, err)
+	assert.Equal(t, "B", ar.Grade)
+	assert.Equal(t, 1, ar.ScanID)
+	assert.True(t, gock.IsDone())
+}
+
+func TestClient_GetLatestAnalysis_Error(t *testing.T) {
+	defer gock.Off()
+
+	site := "www.ssllabs.com"
+
+	gock.New(baseURL).
+		Get("analyze").
+		MatchParam("host", site).
+		Reply(200).
+		BodyString(`{"error":"invalid-hostname"}`)
+
+	c, err := NewClient(Config{Timeout: 10})
+	assert.NoError(t, err)
+
+	gock.InterceptClient(c.client)
+	defer gock.RestoreClient(c.client)
+
+	_, err = c.GetLatestAnalysis(site)
+	assert.True(t, errors.Is(err, ErrInvalidHostname))
+}
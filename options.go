@@ -0,0 +1,342 @@
+// options.go
+//
+// Copyright 2018 © by Ollivier Robert <roberto@keltia.net>
+
+package observatory
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Option is for giving options to New
+type Option func(*Client) error
+
+// proxyConfig is the explicit proxy configuration, the environment is used if empty
+type proxyConfig struct {
+	url      *url.URL
+	user     string
+	password string
+	noProxy  string
+}
+
+// New creates a client with the given options
+func New(opts ...Option) (*Client, error) {
+	return newClient(baseURL, DefaultRetry, opts...)
+}
+
+// WithBaseURL sets the API endpoint
+func WithBaseURL(baseurl string) Option {
+	return func(c *Client) error {
+		u, err := url.Parse(baseurl)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.Errorf("invalid base URL %q", baseurl)
+		}
+		c.baseurl = baseurl
+		return nil
+	}
+}
+
+// WithTimeout sets the timeout of each HTTP request
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) error {
+		if timeout <= 0 {
+			return errors.Errorf("invalid timeout %v", timeout)
+		}
+		c.timeout = timeout
+		return nil
+	}
+}
+
+// WithRetries sets how many times a scan not yet finished is checked
+func WithRetries(retries int) Option {
+	return func(c *Client) error {
+		if retries < 1 {
+			return errors.Errorf("invalid number of retries %d", retries)
+		}
+		c.retries = retries
+		return nil
+	}
+}
+
+// WithPollInterval sets the delay between two checks of a scan not yet finished
+func WithPollInterval(poll time.Duration) Option {
+	return func(c *Client) error {
+		if poll <= 0 {
+			return errors.Errorf("invalid poll interval %v", poll)
+		}
+		c.poll = poll
+		return nil
+	}
+}
+
+// WithMaxWait sets the maximum time waiting for a scan to finish
+func WithMaxWait(wait time.Duration) Option {
+	return func(c *Client) error {
+		if wait <= 0 {
+			return errors.Errorf("invalid maximum wait %v", wait)
+		}
+		c.maxWait = wait
+		return nil
+	}
+}
+
+// WithRetryPolicy sets how the transient HTTP failures are retried
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(c *Client) error {
+		if err := p.validate(); err != nil {
+			return err
+		}
+		c.retry = p
+		return nil
+	}
+}
+
+// WithRateLimit limits the API calls to rate per second with bursts of burst calls
+func WithRateLimit(rate float64, burst int) Option {
+	return func(c *Client) error {
+		if rate <= 0 || burst < 1 {
+			return errors.Errorf("invalid rate limit %v/%d", rate, burst)
+		}
+		c.limiter = newLimiter(rate, burst)
+		return nil
+	}
+}
+
+// WithRescanCooldown sets the minimum delay between two rescans of a site, zero to
+// disable, and what to do with a rescan asked during that time.
+func WithRescanCooldown(delay time.Duration, policy CooldownPolicy) Option {
+	return func(c *Client) error {
+		if delay < 0 {
+			return errors.Errorf("invalid rescan cooldown %v", delay)
+		}
+		if policy != CooldownFallback && policy != CooldownWait {
+			return errors.Errorf("unknown cooldown policy %d", policy)
+		}
+		c.cooldowns = newCooldowns(delay)
+		c.cooldownPolicy = policy
+		return nil
+	}
+}
+
+// WithProgress sends the events of each analysis to fn
+func WithProgress(fn ProgressFunc) Option {
+	return func(c *Client) error {
+		if fn == nil {
+			return errors.New("nil progress func")
+		}
+		c.progress = fn
+		return nil
+	}
+}
+
+// WithLogger sends the log messages to l, *slog.Logger can be used directly
+func WithLogger(l Logger) Option {
+	return func(c *Client) error {
+		if l == nil {
+			return errors.New("nil logger")
+		}
+		c.logger = l
+		return nil
+	}
+}
+
+// withLevel is the Config.Log verbosity of the default logger, 1: verbose, 2: debug
+func withLevel(level int) Option {
+	return func(c *Client) error {
+		if level < 0 {
+			return errors.Errorf("invalid log level %d", level)
+		}
+		c.level = level
+		return nil
+	}
+}
+
+// WithHTTPClient uses hc for all requests, the timeout, transport and proxy options are then ignored
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) error {
+		if hc == nil {
+			return errors.New("nil HTTP client")
+		}
+		c.client = hc
+		return nil
+	}
+}
+
+// WithTransport replaces the default proxy-aware transport
+func WithTransport(trsp http.RoundTripper) Option {
+	return func(c *Client) error {
+		if trsp == nil {
+			return errors.New("nil transport")
+		}
+		c.transport = trsp
+		return nil
+	}
+}
+
+// WithCacheTTL sets how long an analysis is reused, zero or negative disables the cache
+func WithCacheTTL(ttl time.Duration) Option {
+	return func(c *Client) error {
+		if ttl == 0 {
+			ttl = -1
+		}
+		c.cache = newCache(ttl, c.cache.size)
+		return nil
+	}
+}
+
+// WithCacheSize sets the maximum number of sites in the cache
+func WithCacheSize(size int) Option {
+	return func(c *Client) error {
+		if size <= 0 {
+			return errors.Errorf("invalid cache size %d", size)
+		}
+		c.cache = newCache(c.cache.ttl, size)
+		return nil
+	}
+}
+
+// WithUserAgent sets the User-Agent header of the requests
+func WithUserAgent(agent string) Option {
+	return func(c *Client) error {
+		if strings.TrimSpace(agent) == "" {
+			return errors.New("empty user agent")
+		}
+		c.agent = agent
+		return nil
+	}
+}
+
+// WithAPIVersion selects the Observatory API: 1 or 2 for the MDN HTTP Observatory
+func WithAPIVersion(version int) Option {
+	return func(c *Client) error {
+		if version != 1 && version != 2 {
+			return errors.Errorf("unknown API version %d", version)
+		}
+		c.api = version
+		return nil
+	}
+}
+
+// WithProxy sets the proxy URL, overriding HTTP_PROXY/HTTPS_PROXY/NO_PROXY
+func WithProxy(proxy string) Option {
+	return func(c *Client) error {
+		str := proxy
+		if !strings.Contains(str, "://") {
+			str = "http://" + str
+		}
+
+		purl, err := url.Parse(str)
+		if err != nil || purl.Host == "" {
+			return errors.Errorf("invalid proxy %q", proxy)
+		}
+		c.proxy.url = purl
+		return nil
+	}
+}
+
+// WithProxyAuth sets the proxy credentials, overriding .netrc
+func WithProxyAuth(user, password string) Option {
+	return func(c *Client) error {
+		if user == "" {
+			return errors.New("empty proxy user")
+		}
+		c.proxy.user, c.proxy.password = user, password
+		return nil
+	}
+}
+
+// WithNoProxy sets the comma-separated list of hosts never going through the proxy
+func WithNoProxy(list string) Option {
+	return func(c *Client) error {
+		c.proxy.noProxy = list
+		return nil
+	}
+}
+
+// configOptions converts the first Config if any
+func configOptions(cnf []Config) []Option {
+	if len(cnf) == 0 {
+		return nil
+	}
+	return cnf[0].options()
+}
+
+// options converts the Config into the equivalent options, zero values are the defaults
+func (cnf Config) options() []Option {
+	var opts []Option
+
+	if cnf.BaseURL != "" {
+		opts = append(opts, WithBaseURL(cnf.BaseURL))
+	}
+	if cnf.Timeout != 0 {
+		opts = append(opts, WithTimeout(time.Duration(cnf.Timeout)*time.Second))
+	}
+	if cnf.Retries != 0 {
+		opts = append(opts, WithRetries(cnf.Retries))
+	}
+	if cnf.PollInterval != 0 {
+		opts = append(opts, WithPollInterval(cnf.PollInterval))
+	}
+	if cnf.MaxWait != 0 {
+		opts = append(opts, WithMaxWait(cnf.MaxWait))
+	}
+	if cnf.RetryPolicy != nil {
+		opts = append(opts, WithRetryPolicy(*cnf.RetryPolicy))
+	}
+	if cnf.RateLimit != 0 {
+		burst := cnf.RateBurst
+		if burst == 0 {
+			burst = 1
+		}
+		opts = append(opts, WithRateLimit(cnf.RateLimit, burst))
+	}
+	if cnf.RescanCooldown != 0 || cnf.CooldownPolicy != CooldownFallback {
+		delay := cnf.RescanCooldown
+		switch {
+		case delay == 0:
+			delay = DefaultRescanCooldown
+		case delay < 0:
+			delay = 0
+		}
+		opts = append(opts, WithRescanCooldown(delay, cnf.CooldownPolicy))
+	}
+	if cnf.Log != 0 {
+		opts = append(opts, withLevel(cnf.Log))
+	}
+	if cnf.Logger != nil {
+		opts = append(opts, WithLogger(cnf.Logger))
+	}
+	if cnf.Progress != nil {
+		opts = append(opts, WithProgress(cnf.Progress))
+	}
+	if cnf.CacheTTL != 0 {
+		opts = append(opts, WithCacheTTL(cnf.CacheTTL))
+	}
+	if cnf.CacheSize != 0 {
+		opts = append(opts, WithCacheSize(cnf.CacheSize))
+	}
+	if cnf.APIVersion != 0 {
+		opts = append(opts, WithAPIVersion(cnf.APIVersion))
+	}
+	if cnf.HTTPClient != nil {
+		opts = append(opts, WithHTTPClient(cnf.HTTPClient))
+	}
+	if cnf.Transport != nil {
+		opts = append(opts, WithTransport(cnf.Transport))
+	}
+	if cnf.Proxy != "" {
+		opts = append(opts, WithProxy(cnf.Proxy))
+	}
+	if cnf.ProxyUser != "" {
+		opts = append(opts, WithProxyAuth(cnf.ProxyUser, cnf.ProxyPassword))
+	}
+	if cnf.NoProxy != "" {
+		opts = append(opts, WithNoProxy(cnf.NoProxy))
+	}
+	return opts
+}
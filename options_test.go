@@ -0,0 +1,139 @@
+package observatory
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	c, err := New()
+	require.NoError(t, err)
+
+	assert.Equal(t, baseURL, c.baseurl)
+	assert.Equal(t, DefaultRetry, c.retries)
+	assert.Equal(t, DefaultWait, c.timeout)
+	assert.Equal(t, DefaultPollInterval, c.poll)
+	assert.Equal(t, "observatory/"+MyVersion, c.agent)
+	assert.Equal(t, DefaultCacheTTL, c.cache.ttl)
+	assert.Equal(t, 1, c.api)
+	assert.Equal(t, 0, c.level)
+	assert.NotNil(t, c.client)
+}
+
+func TestNew_Options(t *testing.T) {
+	hc := &http.Client{}
+
+	c, err := New(
+		WithBaseURL(testURL),
+		WithTimeout(3*time.Second),
+		WithRetries(7),
+		WithPollInterval(time.Second),
+		WithHTTPClient(hc),
+		WithCacheTTL(time.Minute),
+		WithCacheSize(10),
+		WithUserAgent("myscanner/1.0"),
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, testURL, c.baseurl)
+	assert.Equal(t, 3*time.Second, c.timeout)
+	assert.Equal(t, 7, c.retries)
+	assert.Equal(t, time.Second, c.poll)
+	assert.Equal(t, hc, c.client)
+	assert.Equal(t, time.Minute, c.cache.ttl)
+	assert.Equal(t, 10, c.cache.size)
+	assert.Equal(t, "myscanner/1.0", c.agent)
+}
+
+func TestNew_Timeout(t *testing.T) {
+	c, err := New(WithTimeout(3 * time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 3*time.Second, c.client.Timeout)
+}
+
+func TestNew_APIVersion(t *testing.T) {
+	c, err := New(WithAPIVersion(2))
+	require.NoError(t, err)
+	assert.Equal(t, baseURLv2, c.baseurl)
+
+	c, err = New(WithAPIVersion(2), WithBaseURL(testURL))
+	require.NoError(t, err)
+	assert.Equal(t, testURL, c.baseurl)
+}
+
+func TestNew_CacheDisabled(t *testing.T) {
+	c, err := New(WithCacheTTL(0))
+	require.NoError(t, err)
+	assert.True(t, c.cache.ttl < 0)
+}
+
+func TestNew_Invalid(t *testing.T) {
+	td := []Option{
+		WithBaseURL(""),
+		WithBaseURL("localhost:8080"),
+		WithBaseURL("ftp://example.com/"),
+		WithTimeout(0),
+		WithTimeout(-time.Second),
+		WithRetries(0),
+		WithPollInterval(0),
+		WithLogger(nil),
+		WithHTTPClient(nil),
+		WithTransport(nil),
+		WithCacheSize(0),
+		WithUserAgent(" "),
+		WithAPIVersion(3),
+		WithProxy("http://"),
+		WithProxyAuth("", "secret"),
+	}
+
+	for i, opt := range td {
+		_, err := New(opt)
+		assert.Error(t, err, "option %d", i)
+	}
+}
+
+func TestNewClient_Invalid(t *testing.T) {
+	td := []Config{
+		{Timeout: -1},
+		{Retries: -1},
+		{Log: -1},
+		{CacheSize: -1},
+		{BaseURL: "example.com"},
+	}
+
+	for _, cnf := range td {
+		_, err := NewClient(cnf)
+		assert.Error(t, err, "%#v", cnf)
+	}
+}
+
+func TestNew_LoggerAgent(t *testing.T) {
+	var agent string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agent = r.Header.Get("User-Agent")
+		fmt.Fprint(w, `{"scan_id":1,"state":"FINISHED","grade":"A","end_time":"Mon, 12 Aug 2024 08:20:18 GMT"}`)
+	}))
+	defer srv.Close()
+
+	var l recLogger
+
+	c, err := New(
+		WithBaseURL(srv.URL),
+		WithLogger(&l),
+		WithUserAgent("myscanner/1.0"),
+	)
+	require.NoError(t, err)
+
+	grade, err := c.GetGrade("www.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "A", grade)
+	assert.Equal(t, "myscanner/1.0", agent)
+	assert.Contains(t, l.String(), "DEBUG request method=GET endpoint=analyze")
+}
@@ -0,0 +1,84 @@
+// progress.go
+//
+// Copyright 2018 © by Ollivier Robert <roberto@keltia.net>
+
+package observatory
+
+import (
+	"time"
+)
+
+// Stage is the step of a scan reported in a Progress event
+type Stage string
+
+// Stages of a scan
+const (
+	StageSubmitted Stage = "submitted"
+	StagePending   Stage = "pending"
+	StageRunning   Stage = "running"
+	StageFinished  Stage = "finished"
+	StageFailed    Stage = "failed"
+)
+
+// Progress is sent to the ProgressFunc at each step of an analysis
+type Progress struct {
+	Host   string
+	Stage  Stage
+	State  ScanState
+	ScanID int
+	// Retry is the number of the check of the scan state
+	Retry int
+	// Elapsed is the time since the beginning of the analysis
+	Elapsed time.Duration
+	// Err is set for StageFailed
+	Err error
+}
+
+// ProgressFunc receives the Progress events.  It is called synchronously, from
+// several goroutines if the Client is shared, so it must be quick and safe for
+// concurrent use.
+type ProgressFunc func(Progress)
+
+// ProgressChan returns a ProgressFunc sending the events to ch, dropping them if
+// ch is not ready.
+func ProgressChan(ch chan<- Progress) ProgressFunc {
+	return func(p Progress) {
+		select {
+		case ch <- p:
+		default:
+		}
+	}
+}
+
+// stageOf maps the scan state to a stage
+func stageOf(state ScanState) Stage {
+	switch state {
+	case StateFinished:
+		return StageFinished
+	case StateRunning:
+		return StageRunning
+	case StatePending, StateStarting:
+		return StagePending
+	}
+	return StageFailed
+}
+
+// report sends an event if there is a ProgressFunc
+func (c *Client) report(site string, stage Stage, ar *Analyze, retry int, start time.Time, err error) {
+	if c.progress == nil {
+		return
+	}
+
+	p := Progress{
+		Host:    site,
+		Stage:   stage,
+		Retry:   retry,
+		Elapsed: time.Since(start),
+		Err:     err,
+	}
+	if ar != nil {
+		p.State = ar.State
+		p.ScanID = ar.ScanID
+	}
+	c.progress(p)
+}
@@ -0,0 +1,189 @@
+package observatory
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder keeps the events it gets
+type recorder struct {
+	mu     sync.Mutex
+	events []Progress
+}
+
+func (r *recorder) record(p Progress) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, p)
+}
+
+func (r *recorder) stages() []Stage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var s []Stage
+	for _, p := range r.events {
+		s = append(s, p.Stage)
+	}
+	return s
+}
+
+func TestStageOf(t *testing.T) {
+	assert.Equal(t, StagePending, stageOf(StatePending))
+	assert.Equal(t, StagePending, stageOf(StateStarting))
+	assert.Equal(t, StageRunning, stageOf(StateRunning))
+	assert.Equal(t, StageFinished, stageOf(StateFinished))
+	assert.Equal(t, StageFailed, stageOf(StateAborted))
+	assert.Equal(t, StageFailed, stageOf(ScanState("FOO")))
+}
+
+func TestProgressChan(t *testing.T) {
+	ch := make(chan Progress, 1)
+	fn := ProgressChan(ch)
+
+	fn(Progress{Stage: StageSubmitted})
+	// Full, dropped
+	fn(Progress{Stage: StageRunning})
+
+	p := <-ch
+	assert.Equal(t, StageSubmitted, p.Stage)
+	assert.Len(t, ch, 0)
+}
+
+func TestClient_Progress(t *testing.T) {
+	var (
+		posts int32
+		r     recorder
+	)
+
+	srv := stateServer(&posts, StatePending, StateRunning, StateFinished)
+	defer srv.Close()
+
+	c, err := NewClient(Config{BaseURL: srv.URL, PollInterval: time.Millisecond, Progress: r.record})
+	require.NoError(t, err)
+
+	grade, err := c.GetGrade("www.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "B", grade)
+
+	assert.Equal(t, []Stage{StageSubmitted, StagePending, StageRunning, StageFinished}, r.stages())
+	for i, p := range r.events {
+		assert.Equal(t, "www.example.com", p.Host)
+		assert.Equal(t, 42, p.ScanID)
+		assert.NoError(t, p.Err)
+		if i > 1 {
+			assert.True(t, p.Retry > r.events[i-1].Retry)
+		}
+		if i > 0 {
+			assert.True(t, p.Elapsed >= r.events[i-1].Elapsed)
+		}
+	}
+	assert.Equal(t, StateFinished, r.events[3].State)
+
+	// Too soon for a rescan, the latest scan is used
+	grade, err = c.GetGrade("www.example.com")
+	assert.True(t, errors.Is(err, ErrRescanTooSoon))
+	assert.Equal(t, "B", grade)
+	require.Len(t, r.stages(), 5)
+	assert.Equal(t, StageFailed, r.stages()[4])
+	assert.True(t, errors.Is(r.events[4].Err, ErrRescanTooSoon))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&posts))
+}
+
+func TestClient_Progress_Failed(t *testing.T) {
+	var (
+		posts int32
+		r     recorder
+	)
+
+	srv := stateServer(&posts, StateRunning, StateFailed)
+	defer srv.Close()
+
+	c, err := New(WithBaseURL(srv.URL), WithPollInterval(time.Millisecond), WithProgress(r.record))
+	require.NoError(t, err)
+
+	_, err = c.GetGrade("www.example.com")
+	require.Error(t, err)
+
+	assert.Equal(t, []Stage{StageSubmitted, StageRunning, StageFailed}, r.stages())
+	assert.True(t, errors.Is(r.events[2].Err, ErrScanFailed))
+	assert.Equal(t, StateFailed, r.events[2].State)
+}
+
+func TestClient_Progress_FailedError(t *testing.T) {
+	var r recorder
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == "POST" {
+			fmt.Fprint(w, `{"scan_id":1,"state":"PENDING"}`)
+			return
+		}
+		fmt.Fprint(w, `{"scan_id":1,"state":"FAILED","error":"site down"}`)
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(Config{BaseURL: srv.URL, Progress: r.record})
+	require.NoError(t, err)
+
+	_, err = c.GetGrade("www.example.com")
+	require.Error(t, err)
+
+	assert.Equal(t, []Stage{StageSubmitted, StageFailed}, r.stages())
+	assert.Equal(t, StateFailed, r.events[1].State)
+	assert.True(t, errors.Is(r.events[1].Err, ErrScanFailed))
+
+	// Same thing with a handle
+	h, err := c.StartScan(context.Background(), "www.example.com", ScanOptions{})
+	require.NoError(t, err)
+
+	_, err = h.Poll(context.Background())
+	require.Error(t, err)
+	assert.True(t, h.Done())
+	assert.Equal(t, []Stage{StageSubmitted, StageFailed, StageSubmitted, StageFailed}, r.stages())
+}
+
+func TestClient_Progress_Cancel(t *testing.T) {
+	var (
+		posts int32
+		r     recorder
+	)
+
+	srv := stateServer(&posts, StatePending)
+	defer srv.Close()
+
+	c, err := NewClient(Config{BaseURL: srv.URL, PollInterval: 5 * time.Millisecond, Progress: r.record})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err = c.GetGradeContext(ctx, "www.example.com")
+	require.Error(t, err)
+
+	// The shared analysis ends after the caller is gone
+	eventually(t, func() bool {
+		s := r.stages()
+		return len(s) > 0 && s[len(s)-1] == StageFailed
+	})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	last := r.events[len(r.events)-1]
+	assert.True(t, errors.Is(last.Err, context.Canceled))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&posts))
+}
+
+func TestWithProgress(t *testing.T) {
+	_, err := New(WithProgress(nil))
+	assert.Error(t, err)
+}
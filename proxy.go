@@ -0,0 +1,147 @@
+// proxy.go
+//
+// Copyright 2018 © by Ollivier Robert <roberto@keltia.net>
+
+package observatory
+
+import (
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/keltia/proxy"
+)
+
+/*
+Proxy selection, in order of precedence:
+
+- WithProxy (Config.Proxy), the environment is then ignored
+- HTTP_PROXY/HTTPS_PROXY/NO_PROXY from the environment
+
+Hosts matching WithNoProxy (Config.NoProxy) never go through the proxy in both cases.
+
+Credentials come from WithProxyAuth (Config.ProxyUser/ProxyPassword) or $HOME/.netrc and are sent
+in the CONNECT request for https and with each request for plain http.
+*/
+
+// proxyTransport returns the given transport or the default one with the proxy settings
+func (c *Client) proxyTransport() (http.RoundTripper, error) {
+	if c.transport != nil {
+		return c.transport, nil
+	}
+
+	c.proxyauth = proxyAuth(c.proxy)
+	c.logger.Debug("proxy", "url", redactURL(c.proxy.url), "auth", redact(c.proxyauth))
+
+	_, trsp := proxy.SetupTransport(c.baseurl)
+	if trsp == nil {
+		trsp = &http.Transport{}
+	}
+	trsp.Proxy = proxyFunc(c.proxy)
+
+	if c.proxyauth == "" {
+		return trsp, nil
+	}
+
+	if trsp.ProxyConnectHeader == nil {
+		trsp.ProxyConnectHeader = http.Header{}
+	}
+	trsp.ProxyConnectHeader.Set("Proxy-Authorization", c.proxyauth)
+	return &proxyAuthTransport{Transport: trsp, auth: c.proxyauth}, nil
+}
+
+// proxyAuth returns the Proxy-Authorization value, explicit credentials first then .netrc
+func proxyAuth(pc proxyConfig) string {
+	if pc.user != "" {
+		auth := pc.user + ":" + pc.password
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(auth))
+	}
+
+	// We do not care whether it fails or not, if it does, just no proxyauth.
+	proxyauth, _ := proxy.SetupProxyAuth()
+	return proxyauth
+}
+
+// proxyFunc selects the proxy for each request
+func proxyFunc(pc proxyConfig) func(*http.Request) (*url.URL, error) {
+	pfunc := http.ProxyFromEnvironment
+	if pc.url != nil {
+		pfunc = http.ProxyURL(pc.url)
+	}
+
+	if pc.noProxy == "" {
+		return pfunc
+	}
+
+	return func(req *http.Request) (*url.URL, error) {
+		if noProxy(pc.noProxy, req.URL) {
+			return nil, nil
+		}
+		return pfunc(req)
+	}
+}
+
+// noProxy checks whether u matches the comma-separated list, same syntax as NO_PROXY
+func noProxy(list string, u *url.URL) bool {
+	host, port := strings.ToLower(u.Hostname()), u.Port()
+
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+			continue
+		case entry == "*":
+			return true
+		}
+
+		// CIDR block
+		if _, ipnet, err := net.ParseCIDR(entry); err == nil {
+			if ip := net.ParseIP(host); ip != nil && ipnet.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		// host:port only matches that port
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			if p != port {
+				continue
+			}
+			entry = h
+		}
+
+		// ".example.com" and "*.example.com" only match subdomains
+		entry = strings.TrimPrefix(entry, "*")
+		if strings.HasPrefix(entry, ".") {
+			if strings.HasSuffix(host, entry) {
+				return true
+			}
+			continue
+		}
+
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyAuthTransport adds the proxy credentials to plain http requests going
+// through the proxy, https ones get them in the CONNECT request.
+type proxyAuthTransport struct {
+	*http.Transport
+	auth string
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *proxyAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "http" && req.Header.Get("Proxy-Authorization") == "" {
+		if purl, err := t.Proxy(req); err == nil && purl != nil {
+			req = req.Clone(req.Context())
+			req.Header.Set("Proxy-Authorization", t.auth)
+		}
+	}
+	return t.Transport.RoundTrip(req)
+}
@@ -0,0 +1,188 @@
+package observatory
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// proxyServer is a forwarding proxy stand-in recording the Proxy-Authorization
+// header, it answers plain http requests itself and refuses CONNECT.
+type proxyServer struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	auths []string
+	hosts []string
+}
+
+func newProxyServer() *proxyServer {
+	ps := &proxyServer{}
+	ps.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ps.mu.Lock()
+		ps.auths = append(ps.auths, r.Header.Get("Proxy-Authorization"))
+		ps.hosts = append(ps.hosts, r.Host)
+		ps.mu.Unlock()
+
+		if r.Method == http.MethodConnect {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		fmt.Fprint(w, `{"scan_id":1,"state":"FINISHED","grade":"A","end_time":"Mon, 12 Aug 2024 08:20:18 GMT"}`)
+	}))
+	return ps
+}
+
+func basic(user, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+}
+
+func TestClient_Proxy_HTTP(t *testing.T) {
+	ps := newProxyServer()
+	defer ps.Close()
+
+	c, err := NewClient(Config{
+		BaseURL:       "http://observatory.example/api/v1",
+		Proxy:         ps.URL,
+		ProxyUser:     "user",
+		ProxyPassword: "secret",
+	})
+	require.NoError(t, err)
+
+	grade, err := c.GetGrade("www.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "A", grade)
+
+	require.Len(t, ps.auths, 2)
+	for i := range ps.auths {
+		assert.Equal(t, basic("user", "secret"), ps.auths[i])
+		assert.Equal(t, "observatory.example", ps.hosts[i])
+	}
+}
+
+func TestClient_Proxy_Connect(t *testing.T) {
+	ps := newProxyServer()
+	defer ps.Close()
+
+	c, err := NewClient(Config{
+		BaseURL:       "https://observatory.example/api/v1",
+		Proxy:         ps.URL,
+		ProxyUser:     "user",
+		ProxyPassword: "secret",
+	})
+	require.NoError(t, err)
+
+	_, err = c.GetGrade("www.example.com")
+	assert.Error(t, err)
+
+	require.NotEmpty(t, ps.auths)
+	assert.Equal(t, basic("user", "secret"), ps.auths[0])
+	assert.Equal(t, "observatory.example:443", ps.hosts[0])
+}
+
+func TestClient_Proxy_Netrc(t *testing.T) {
+	ps := newProxyServer()
+	defer ps.Close()
+
+	dir, err := ioutil.TempDir("", "observatory")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	netrc := filepath.Join(dir, "netrc")
+	require.NoError(t, ioutil.WriteFile(netrc, []byte("machine proxy login netuser password netpass\n"), 0600))
+
+	old, ok := os.LookupEnv("NETRC")
+	os.Setenv("NETRC", netrc)
+	defer func() {
+		if ok {
+			os.Setenv("NETRC", old)
+		} else {
+			os.Unsetenv("NETRC")
+		}
+	}()
+
+	c, err := NewClient(Config{BaseURL: "http://observatory.example/api/v1", Proxy: ps.URL})
+	require.NoError(t, err)
+
+	_, err = c.GetGrade("www.example.com")
+	require.NoError(t, err)
+	require.NotEmpty(t, ps.auths)
+	assert.Equal(t, basic("netuser", "netpass"), ps.auths[0])
+
+	// Explicit credentials win
+	c, err = NewClient(Config{BaseURL: "http://observatory.example/api/v1", Proxy: ps.URL, ProxyUser: "user"})
+	require.NoError(t, err)
+
+	c.Purge()
+	_, err = c.GetGrade("www.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, basic("user", ""), ps.auths[len(ps.auths)-1])
+}
+
+func TestClient_Proxy_NoProxy(t *testing.T) {
+	ps := newProxyServer()
+	defer ps.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.Header.Get("Proxy-Authorization"))
+		fmt.Fprint(w, `{"scan_id":1,"state":"FINISHED","grade":"B","end_time":"Mon, 12 Aug 2024 08:20:18 GMT"}`)
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(Config{
+		BaseURL:   srv.URL,
+		Proxy:     ps.URL,
+		ProxyUser: "user",
+		NoProxy:   "example.org, 127.0.0.0/8",
+	})
+	require.NoError(t, err)
+
+	grade, err := c.GetGrade("www.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "B", grade)
+	assert.Empty(t, ps.auths)
+}
+
+func TestNewClient_BadProxy(t *testing.T) {
+	_, err := NewClient(Config{Proxy: "http://"})
+	assert.Error(t, err)
+}
+
+func TestNoProxy(t *testing.T) {
+	td := []struct {
+		list string
+		url  string
+		res  bool
+	}{
+		{"", "https://example.com/", false},
+		{"*", "https://example.com/", true},
+		{"example.com", "https://example.com/", true},
+		{"example.com", "https://www.example.com/", true},
+		{".example.com", "https://www.example.com/", true},
+		{"*.example.com", "https://Www.Example.com/", true},
+		{".example.com", "https://example.com/", false},
+		{"*.example.com", "https://example.com/", false},
+		{"example.com", "https://badexample.com/", false},
+		{"foo.org, example.com:8443", "https://example.com:8443/", true},
+		{"example.com:8443", "https://example.com/", false},
+		{"10.0.0.0/8", "http://10.1.2.3/", true},
+		{"10.0.0.0/8", "http://192.168.1.1/", false},
+		{"192.168.1.1", "http://192.168.1.1:8080/", true},
+	}
+
+	for _, d := range td {
+		u, err := url.Parse(d.url)
+		require.NoError(t, err)
+		assert.Equal(t, d.res, noProxy(d.list, u), "%s / %s", d.list, d.url)
+	}
+}
@@ -0,0 +1,128 @@
+// ratelimit.go
+//
+// Copyright 2018 © by Ollivier Robert <roberto@keltia.net>
+
+package observatory
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRescanCooldown is the minimum delay between two rescans of a site enforced by the API
+	DefaultRescanCooldown = 3 * time.Minute
+)
+
+// CooldownPolicy is what to do when a rescan is asked during the cooldown of a site
+type CooldownPolicy int
+
+const (
+	// CooldownFallback returns the latest finished scan instead, see GetLatestAnalysis,
+	// with an error matching ErrRescanTooSoon
+	CooldownFallback CooldownPolicy = iota
+	// CooldownWait waits for the end of the cooldown before asking for the rescan
+	CooldownWait
+)
+
+// limiter is a token bucket, safe for concurrent use
+type limiter struct {
+	mu sync.Mutex
+
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(rate float64, burst int) *limiter {
+	return &limiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// reserve takes a token and returns how long to wait before using it
+func (l *limiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	// Tokens can go negative, the next callers wait longer
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// wait blocks until a request can be made, nil limiter means no limit
+func (l *limiter) wait(ctx context.Context) error {
+	if l == nil {
+		return ctx.Err()
+	}
+
+	d := l.reserve()
+	if d == 0 {
+		return ctx.Err()
+	}
+	return sleepContext(ctx, d)
+}
+
+// cooldowns records the end of the rescan cooldown of each site, safe for concurrent use
+type cooldowns struct {
+	mu sync.Mutex
+
+	delay time.Duration
+	until map[string]time.Time
+}
+
+func newCooldowns(delay time.Duration) *cooldowns {
+	return &cooldowns{
+		delay: delay,
+		until: map[string]time.Time{},
+	}
+}
+
+// start begins the cooldown of host, forgetting the ones already over
+func (c *cooldowns) start(host string) {
+	if c.delay <= 0 {
+		return
+	}
+
+	host = strings.ToLower(host)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for h, t := range c.until {
+		if !t.After(now) {
+			delete(c.until, h)
+		}
+	}
+	c.until[host] = now.Add(c.delay)
+}
+
+// remaining is how long before host can be rescanned
+func (c *cooldowns) remaining(host string) time.Duration {
+	host = strings.ToLower(host)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if d := time.Until(c.until[host]); d > 0 {
+		return d
+	}
+	return 0
+}
@@ -0,0 +1,219 @@
+package observatory
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rescanServer counts the POSTs, the first ones answer with the given body
+func rescanServer(posts *int32, first ...string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			n := int(atomic.AddInt32(posts, 1))
+			if n <= len(first) {
+				fmt.Fprint(w, first[n-1])
+				return
+			}
+			fmt.Fprint(w, `{"scan_id":1,"state":"PENDING"}`)
+			return
+		}
+		fmt.Fprint(w, finished)
+	}))
+}
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter(20, 2)
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		require.NoError(t, l.wait(context.Background()))
+	}
+	assert.True(t, time.Since(start) >= 90*time.Millisecond)
+}
+
+func TestLimiter_Cancel(t *testing.T) {
+	var l *limiter
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// No limit but still cancelled
+	assert.Equal(t, context.Canceled, l.wait(ctx))
+
+	l = newLimiter(0.1, 1)
+	require.NoError(t, l.wait(context.Background()))
+
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, l.wait(ctx))
+}
+
+func TestCooldowns(t *testing.T) {
+	c := newCooldowns(50 * time.Millisecond)
+	assert.Equal(t, time.Duration(0), c.remaining("www.example.com"))
+
+	c.start("WWW.example.com")
+	assert.True(t, c.remaining("www.example.com") > 0)
+	assert.Equal(t, time.Duration(0), c.remaining("example.com"))
+
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, time.Duration(0), c.remaining("www.example.com"))
+
+	// Old ones are removed
+	c.start("example.com")
+	assert.Len(t, c.until, 1)
+
+	c = newCooldowns(0)
+	c.start("www.example.com")
+	assert.Equal(t, time.Duration(0), c.remaining("www.example.com"))
+}
+
+func TestClient_RateLimit(t *testing.T) {
+	var posts int32
+
+	srv := rescanServer(&posts)
+	defer srv.Close()
+
+	c, err := NewClient(Config{BaseURL: srv.URL, RateLimit: 20})
+	require.NoError(t, err)
+
+	start := time.Now()
+	_, err = c.GetGrade("www.example.com")
+	require.NoError(t, err)
+	assert.True(t, time.Since(start) >= 45*time.Millisecond)
+
+	_, err = New(WithRateLimit(0, 1))
+	assert.Error(t, err)
+	_, err = New(WithRateLimit(1, 0))
+	assert.Error(t, err)
+}
+
+func TestClient_Cooldown_Fallback(t *testing.T) {
+	var posts int32
+
+	srv := rescanServer(&posts)
+	defer srv.Close()
+
+	c, err := NewClient(Config{BaseURL: srv.URL, CacheTTL: -1})
+	require.NoError(t, err)
+
+	grade, err := c.GetGrade("www.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "A", grade)
+
+	// The latest scan, but we are told
+	for i := 0; i < 2; i++ {
+		grade, err := c.GetGrade("www.example.com")
+		assert.True(t, errors.Is(err, ErrRescanTooSoon))
+		assert.Equal(t, "A", grade)
+	}
+	assert.EqualValues(t, 1, atomic.LoadInt32(&posts))
+
+	// No rescan, no cooldown
+	_, err = c.GetGrade("www.example.com", ScanOptions{Hidden: true})
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&posts))
+}
+
+func TestClient_Cooldown_Wait(t *testing.T) {
+	var posts int32
+
+	srv := rescanServer(&posts)
+	defer srv.Close()
+
+	c, err := NewClient(Config{
+		BaseURL:        srv.URL,
+		CacheTTL:       -1,
+		RescanCooldown: 50 * time.Millisecond,
+		CooldownPolicy: CooldownWait,
+	})
+	require.NoError(t, err)
+
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		_, err := c.GetGrade("www.example.com")
+		require.NoError(t, err)
+	}
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&posts))
+
+	// The wait can be cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = c.GetGradeContext(ctx, "www.example.com")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestClient_Cooldown_Disabled(t *testing.T) {
+	var posts int32
+
+	srv := rescanServer(&posts)
+	defer srv.Close()
+
+	c, err := NewClient(Config{BaseURL: srv.URL, CacheTTL: -1, RescanCooldown: -1})
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		_, err := c.GetGrade("www.example.com")
+		require.NoError(t, err)
+	}
+	assert.EqualValues(t, 2, atomic.LoadInt32(&posts))
+
+	_, err = New(WithRescanCooldown(-time.Second, CooldownWait))
+	assert.Error(t, err)
+	_, err = New(WithRescanCooldown(time.Second, CooldownPolicy(42)))
+	assert.Error(t, err)
+}
+
+func TestClient_Cooldown_TooSoon(t *testing.T) {
+	var posts int32
+
+	srv := rescanServer(&posts, `{"error":"rescan-attempt-too-soon","text":"Rescans attempts cannot be made more often than every 3 minutes"}`)
+	defer srv.Close()
+
+	c, err := NewClient(Config{BaseURL: srv.URL, CacheTTL: -1})
+	require.NoError(t, err)
+
+	// Same as our own cooldown
+	grade, err := c.GetGrade("www.example.com")
+	assert.True(t, errors.Is(err, ErrRescanTooSoon))
+	assert.Equal(t, "A", grade)
+
+	// We now know better than asking again
+	grade, err = c.GetGrade("www.example.com")
+	assert.True(t, errors.Is(err, ErrRescanTooSoon))
+	assert.Equal(t, "A", grade)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&posts))
+}
+
+func TestClient_Cooldown_TooSoonWait(t *testing.T) {
+	var posts int32
+
+	srv := rescanServer(&posts, `{"error":"rescan-attempt-too-soon"}`)
+	defer srv.Close()
+
+	c, err := NewClient(Config{
+		BaseURL:        srv.URL,
+		CacheTTL:       -1,
+		RescanCooldown: 50 * time.Millisecond,
+		CooldownPolicy: CooldownWait,
+	})
+	require.NoError(t, err)
+
+	start := time.Now()
+	grade, err := c.GetGrade("www.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "A", grade)
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&posts))
+}
@@ -0,0 +1,99 @@
+// recent.go
+//
+// Copyright 2018 © by Ollivier Robert <roberto@keltia.net>
+
+package observatory
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// RecentScansFilter selects the scans returned by GetRecentScans, nil scores and a
+// zero Num are not sent
+type RecentScansFilter struct {
+	// Min is the minimum score
+	Min *int
+	// Max is the maximum score, 0 is a valid one
+	Max *int
+	// Num is the number of scans (API maximum is 25)
+	Num int
+}
+
+// Score returns a pointer to score, for RecentScansFilter
+func Score(score int) *int {
+	return &score
+}
+
+// RecentScan is a site and its grade
+type RecentScan struct {
+	Host  string
+	Grade string
+}
+
+// params returns the query parameters for the filter
+func (f RecentScansFilter) params() map[string]string {
+	opts := map[string]string{}
+	if f.Min != nil {
+		opts["min"] = fmt.Sprintf("%d", *f.Min)
+	}
+	if f.Max != nil {
+		opts["max"] = fmt.Sprintf("%d", *f.Max)
+	}
+	if f.Num != 0 {
+		opts["num"] = fmt.Sprintf("%d", f.Num)
+	}
+	return opts
+}
+
+// GetRecentScans returns the most recent public scans, most recent first
+func (c *Client) GetRecentScans(filter RecentScansFilter) ([]RecentScan, error) {
+	return c.GetRecentScansContext(context.Background(), filter)
+}
+
+// GetRecentScansContext is GetRecentScans with a context to cancel the call
+func (c *Client) GetRecentScansContext(ctx context.Context, filter RecentScansFilter) ([]RecentScan, error) {
+	c.logger.Debug("GetRecentScans")
+
+	if c.api == 2 {
+		return nil, ErrNotSupported
+	}
+
+	raw, err := c.callAPI(ctx, "GET", "getRecentScans", nil, filter.params())
+	if err != nil {
+		return nil, errors.Wrap(err, "GetRecentScans")
+	}
+
+	rs, err := decodeRecentScans(raw)
+	return rs, errors.Wrap(err, "GetRecentScans/decode")
+}
+
+// decodeRecentScans reads the {"host": "grade", ...} object keeping the API order
+func decodeRecentScans(raw []byte) ([]RecentScan, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil, errors.Errorf("expected object, got %v", t)
+	}
+
+	rs := []RecentScan{}
+	for dec.More() {
+		var r RecentScan
+
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		r.Host = t.(string)
+
+		if err := dec.Decode(&r.Grade); err != nil {
+			return nil, err
+		}
+		rs = append(rs, r)
+	}
+	return rs, nil
+}
@@ -0,0 +1,78 @@
+package observatory
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/h2non/gock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecentScansFilter_Params(t *testing.T) {
+	assert.Empty(t, RecentScansFilter{}.params())
+	assert.Equal(t, map[string]string{"max": "20", "num": "5"}, RecentScansFilter{Max: Score(20), Num: 5}.params())
+	assert.Equal(t, map[string]string{"min": "90"}, RecentScansFilter{Min: Score(90)}.params())
+	assert.Equal(t, map[string]string{"min": "0", "max": "0"}, RecentScansFilter{Min: Score(0), Max: Score(0)}.params())
+}
+
+func TestDecodeRecentScans(t *testing.T) {
+	rs, err := decodeRecentScans([]byte(`{"b.example.com":"A","a.example.com":null}`))
+	require.NoError(t, err)
+	assert.Equal(t, []RecentScan{{"b.example.com", "A"}, {"a.example.com", ""}}, rs)
+
+	rs, err = decodeRecentScans([]byte(`{}`))
+	require.NoError(t, err)
+	assert.Empty(t, rs)
+
+	_, err = decodeRecentScans([]byte(`[]`))
+	assert.Error(t, err)
+
+	_, err = decodeRecentScans([]byte(`{"a.example.com":1}`))
+	assert.Error(t, err)
+}
+
+func TestClient_GetRecentScans(t *testing.T) {
+	defer gock.Off()
+
+	ftr, err := ioutil.ReadFile("testdata/recent-scans.json")
+	require.NoError(t, err)
+
+	gock.New(baseURL).
+		Get("getRecentScans").
+		MatchParam("max", "20").
+		MatchParam("num", "5").
+		Reply(200).
+		BodyString(string(ftr))
+
+	c, err := NewClient(Config{Timeout: 10})
+	require.NoError(t, err)
+
+	gock.InterceptClient(c.client)
+	defer gock.RestoreClient(c.client)
+
+	rs, err := c.GetRecentScans(RecentScansFilter{Max: Score(20), Num: 5})
+	require.NoError(t, err)
+	require.Len(t, rs, 5)
+	assert.Equal(t, RecentScan{"www.example.com", "F"}, rs[0])
+	assert.Equal(t, RecentScan{"site.example.org", "D+"}, rs[1])
+	assert.Equal(t, RecentScan{"old.example.io", "F"}, rs[4])
+}
+
+func TestClient_GetRecentScans_Error(t *testing.T) {
+	defer gock.Off()
+
+	gock.New(baseURL).
+		Get("getRecentScans").
+		Reply(503).
+		BodyString("down")
+
+	c, err := NewClient(Config{Timeout: 10, RetryPolicy: &RetryPolicy{MaxAttempts: 1}})
+	require.NoError(t, err)
+
+	gock.InterceptClient(c.client)
+	defer gock.RestoreClient(c.client)
+
+	_, err = c.GetRecentScans(RecentScansFilter{})
+	assert.Error(t, err)
+}
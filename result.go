@@ -0,0 +1,177 @@
+// result.go
+//
+// Copyright 2018 © by Ollivier Robert <roberto@keltia.net>
+
+package observatory
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// fields maps the API test names to the Result fields
+func (r *Result) fields() map[string]*Scan {
+	return map[string]*Scan{
+		"content-security-policy":       &r.ContentSecurityPolicy,
+		"contribute":                    &r.Contribute,
+		"cookies":                       &r.Cookies,
+		"cross-origin-resource-sharing": &r.CrossOriginResourceSharing,
+		"public-key-pinning":            &r.PublicKeyPinning,
+		"redirection":                   &r.Redirection,
+		"referrer-policy":               &r.ReferrerPolicy,
+		"strict-transport-security":     &r.StrictTransportSecurity,
+		"subresource-integrity":         &r.SubresourceIntegrity,
+		"x-content-type-options":        &r.XContentTypeOptions,
+		"x-frame-options":               &r.XFrameOptions,
+		"x-xss-protection":              &r.XXSSProtection,
+	}
+}
+
+// UnmarshalJSON fills in the known tests and keeps the others in Others
+func (r *Result) UnmarshalJSON(data []byte) error {
+	var tests map[string]Scan
+
+	if err := json.Unmarshal(data, &tests); err != nil {
+		return err
+	}
+
+	*r = Result{}
+	fields := r.fields()
+	for name, s := range tests {
+		if f, ok := fields[name]; ok {
+			*f = s
+			continue
+		}
+		if r.Others == nil {
+			r.Others = map[string]Scan{}
+		}
+		r.Others[name] = s
+	}
+	return nil
+}
+
+// Test returns the result of the test called name (the API key, like "cookies")
+func (r *Result) Test(name string) (Scan, bool) {
+	if f, ok := r.fields()[name]; ok {
+		return *f, f.Name != ""
+	}
+	s, ok := r.Others[name]
+	return s, ok
+}
+
+// Tests returns all the tests present in the results, indexed by name
+func (r *Result) Tests() map[string]Scan {
+	tests := map[string]Scan{}
+	for name, f := range r.fields() {
+		if f.Name != "" {
+			tests[name] = *f
+		}
+	}
+	for name, s := range r.Others {
+		tests[name] = s
+	}
+	return tests
+}
+
+// DecodeOutput decodes the output of the test into v
+func (s Scan) DecodeOutput(v interface{}) error {
+	if len(s.Output) == 0 {
+		return errors.Errorf("no output for %q", s.Name)
+	}
+	return errors.Wrapf(json.Unmarshal(s.Output, v), "output of %q", s.Name)
+}
+
+// CSPOutput returns the decoded output of the content-security-policy test
+func (r *Result) CSPOutput() (*CSPOutput, error) {
+	var out CSPOutput
+
+	err := r.ContentSecurityPolicy.DecodeOutput(&out)
+	return &out, err
+}
+
+// UnmarshalJSON deals with the fields the API sends with different types
+func (ck *Cookie) UnmarshalJSON(data []byte) error {
+	type plain Cookie
+
+	aux := struct {
+		*plain
+		SameSite json.RawMessage `json:"samesite"`
+		MaxAge   json.RawMessage `json:"max-age"`
+	}{plain: (*plain)(ck)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	// samesite is either false or the value of the flag
+	var ss string
+	if json.Unmarshal(aux.SameSite, &ss) == nil {
+		ck.SameSite = ss
+	}
+
+	// max-age is either a number or a string
+	ma := strings.Trim(string(aux.MaxAge), `"`)
+	if n, err := strconv.ParseInt(ma, 10, 64); err == nil {
+		ck.MaxAge = &n
+	}
+	return nil
+}
+
+// Session returns true if the cookie has no expiration
+func (ck Cookie) Session() bool {
+	return ck.Expires == nil && ck.MaxAge == nil
+}
+
+// CookiesOutput returns the decoded output of the cookies test
+func (r *Result) CookiesOutput() (*CookiesOutput, error) {
+	var out CookiesOutput
+
+	err := r.Cookies.DecodeOutput(&out)
+	return &out, err
+}
+
+// HSTSOutput returns the decoded output of the strict-transport-security test
+func (r *Result) HSTSOutput() (*HSTSOutput, error) {
+	var out HSTSOutput
+
+	err := r.StrictTransportSecurity.DecodeOutput(&out)
+	return &out, err
+}
+
+// RedirectionOutput returns the decoded output of the redirection test
+func (r *Result) RedirectionOutput() (*RedirectionOutput, error) {
+	var out RedirectionOutput
+
+	err := r.Redirection.DecodeOutput(&out)
+	return &out, err
+}
+
+// HasHSTSPreload returns true if the site is preloaded or asks to be
+func (r *Result) HasHSTSPreload() (bool, error) {
+	out, err := r.HSTSOutput()
+	if err != nil {
+		return false, err
+	}
+	return out.Preloaded || out.Preload, nil
+}
+
+// InsecureCookies returns the sorted names of the cookies without the Secure flag
+func (r *Result) InsecureCookies() ([]string, error) {
+	out, err := r.CookiesOutput()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for name, ck := range out.Data {
+		if !ck.Secure {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
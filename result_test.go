@@ -0,0 +1,239 @@
+package observatory
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResult_Unmarshal(t *testing.T) {
+	ftr, err := ioutil.ReadFile("testdata/ssllabs-8507653.json")
+	require.NoError(t, err)
+
+	var res Result
+
+	require.NoError(t, json.Unmarshal(ftr, &res))
+
+	// Every known test must have been decoded
+	for name, f := range res.fields() {
+		assert.Equal(t, name, f.Name)
+	}
+	assert.Empty(t, res.Others)
+
+	assert.Equal(t, "csp-implemented-with-no-unsafe", res.ContentSecurityPolicy.Result)
+	assert.Equal(t, 5, res.ContentSecurityPolicy.ScoreModifier)
+	assert.True(t, res.StrictTransportSecurity.Pass)
+	assert.Equal(t, "x-xss-protection-enabled-mode-block", res.XXSSProtection.Result)
+	assert.Len(t, res.Tests(), 12)
+}
+
+func TestResult_Unknown(t *testing.T) {
+	ftr := `{"cookies":{"name":"cookies","pass":true},"cross-origin-resource-policy":{"name":"cross-origin-resource-policy","pass":false,"result":"corp-not-implemented"}}`
+
+	var res Result
+
+	require.NoError(t, json.Unmarshal([]byte(ftr), &res))
+	assert.True(t, res.Cookies.Pass)
+	require.Len(t, res.Others, 1)
+	assert.Equal(t, "corp-not-implemented", res.Others["cross-origin-resource-policy"].Result)
+
+	s, ok := res.Test("cross-origin-resource-policy")
+	assert.True(t, ok)
+	assert.False(t, s.Pass)
+
+	s, ok = res.Test("cookies")
+	assert.True(t, ok)
+	assert.True(t, s.Pass)
+
+	_, ok = res.Test("redirection")
+	assert.False(t, ok)
+
+	_, ok = res.Test("foo")
+	assert.False(t, ok)
+
+	assert.Len(t, res.Tests(), 2)
+}
+
+func TestResult_Bad(t *testing.T) {
+	var res Result
+
+	assert.Error(t, json.Unmarshal([]byte(`[]`), &res))
+}
+
+func TestResult_CSPOutput(t *testing.T) {
+	ftr, err := ioutil.ReadFile("testdata/ssllabs-8507653.json")
+	require.NoError(t, err)
+
+	var res Result
+
+	require.NoError(t, json.Unmarshal(ftr, &res))
+
+	csp, err := res.CSPOutput()
+	require.NoError(t, err)
+	assert.True(t, csp.HTTP)
+	assert.False(t, csp.Meta)
+	assert.Contains(t, csp.Data["default-src"], "'self'")
+	assert.Len(t, csp.Data["default-src"], 6)
+
+	require.NotNil(t, csp.Policy)
+	assert.True(t, csp.Policy.InsecureBaseURI)
+	assert.True(t, csp.Policy.InsecureFormAction)
+	assert.False(t, csp.Policy.UnsafeInline)
+	assert.False(t, csp.Policy.UnsafeEval)
+	assert.False(t, csp.Policy.StrictDynamic)
+}
+
+func TestResult_CSPOutput_None(t *testing.T) {
+	ftr, err := ioutil.ReadFile("testdata/lbl.gov.data.json")
+	require.NoError(t, err)
+
+	var res Result
+
+	require.NoError(t, json.Unmarshal(ftr, &res))
+
+	csp, err := res.CSPOutput()
+	require.NoError(t, err)
+	assert.False(t, csp.HTTP)
+	assert.Nil(t, csp.Data)
+	assert.Nil(t, csp.Policy)
+}
+
+func TestResult_CSPOutput_Meta(t *testing.T) {
+	ftr := `{"content-security-policy":{"name":"content-security-policy","output":{"data":{"script-src":["'self'","'unsafe-inline'","'strict-dynamic'"]},"http":false,"meta":true,"policy":{"strictDynamic":true,"unsafeInline":true,"unsafeEval":true}}}}`
+
+	var res Result
+
+	require.NoError(t, json.Unmarshal([]byte(ftr), &res))
+
+	csp, err := res.CSPOutput()
+	require.NoError(t, err)
+	assert.False(t, csp.HTTP)
+	assert.True(t, csp.Meta)
+	assert.Equal(t, []string{"'self'", "'unsafe-inline'", "'strict-dynamic'"}, csp.Data["script-src"])
+	assert.True(t, csp.Policy.StrictDynamic)
+	assert.True(t, csp.Policy.UnsafeInline)
+	assert.True(t, csp.Policy.UnsafeEval)
+}
+
+func TestScan_DecodeOutput(t *testing.T) {
+	var res Result
+
+	_, err := res.CSPOutput()
+	assert.Error(t, err)
+
+	s := Scan{Name: "foo", Output: json.RawMessage(`[]`)}
+	assert.Error(t, s.DecodeOutput(&CSPOutput{}))
+}
+
+func TestResult_CookiesOutput(t *testing.T) {
+	ftr, err := ioutil.ReadFile("testdata/ssllabs-8507653.json")
+	require.NoError(t, err)
+
+	var res Result
+
+	require.NoError(t, json.Unmarshal(ftr, &res))
+
+	out, err := res.CookiesOutput()
+	require.NoError(t, err)
+	assert.False(t, out.SameSite)
+	require.Len(t, out.Data, 1)
+
+	ck := out.Data["JSESSIONID"]
+	assert.Equal(t, "www.ssllabs.com", ck.Domain)
+	assert.Equal(t, "/", ck.Path)
+	assert.True(t, ck.Secure)
+	assert.True(t, ck.HTTPOnly)
+	assert.Equal(t, "", ck.SameSite)
+	assert.Nil(t, ck.Port)
+	assert.True(t, ck.Session())
+
+	names, err := res.InsecureCookies()
+	require.NoError(t, err)
+	assert.Empty(t, names)
+}
+
+func TestResult_InsecureCookies(t *testing.T) {
+	ftr := `{"cookies":{"name":"cookies","output":{"data":{
+		"b":{"secure":false,"httponly":true,"samesite":"Strict","expires":1600000000,"max-age":null},
+		"a":{"secure":false,"httponly":false,"samesite":false,"expires":null,"max-age":"3600"},
+		"c":{"secure":true,"httponly":true,"samesite":"Lax","expires":null,"max-age":60}
+	},"sameSite":false}}}`
+
+	var res Result
+
+	require.NoError(t, json.Unmarshal([]byte(ftr), &res))
+
+	out, err := res.CookiesOutput()
+	require.NoError(t, err)
+
+	assert.Equal(t, "Strict", out.Data["b"].SameSite)
+	assert.EqualValues(t, 1600000000, *out.Data["b"].Expires)
+	assert.Nil(t, out.Data["b"].MaxAge)
+	assert.Equal(t, "", out.Data["a"].SameSite)
+	assert.EqualValues(t, 3600, *out.Data["a"].MaxAge)
+	assert.EqualValues(t, 60, *out.Data["c"].MaxAge)
+	assert.False(t, out.Data["c"].Session())
+
+	names, err := res.InsecureCookies()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, names)
+}
+
+func TestResult_HSTSOutput(t *testing.T) {
+	ftr, err := ioutil.ReadFile("testdata/ssllabs-8507653.json")
+	require.NoError(t, err)
+
+	var res Result
+
+	require.NoError(t, json.Unmarshal(ftr, &res))
+
+	out, err := res.HSTSOutput()
+	require.NoError(t, err)
+	assert.Equal(t, "max-age=31536000", out.Data)
+	assert.EqualValues(t, 31536000, *out.MaxAge)
+	assert.False(t, out.IncludeSubDomains)
+	assert.False(t, out.Preload)
+	assert.False(t, out.Preloaded)
+
+	ok, err := res.HasHSTSPreload()
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestResult_HasHSTSPreload(t *testing.T) {
+	ftr := `{"strict-transport-security":{"name":"strict-transport-security","output":{"data":"max-age=63072000; includeSubDomains; preload","includeSubDomains":true,"max-age":63072000,"preload":true,"preloaded":true}}}`
+
+	var res Result
+
+	require.NoError(t, json.Unmarshal([]byte(ftr), &res))
+
+	ok, err := res.HasHSTSPreload()
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	_, err = (&Result{}).HasHSTSPreload()
+	assert.Error(t, err)
+}
+
+func TestResult_RedirectionOutput(t *testing.T) {
+	ftr, err := ioutil.ReadFile("testdata/lbl.gov.data.json")
+	require.NoError(t, err)
+
+	var res Result
+
+	require.NoError(t, json.Unmarshal(ftr, &res))
+
+	out, err := res.RedirectionOutput()
+	require.NoError(t, err)
+	assert.True(t, out.Redirects)
+	assert.Equal(t, "http://www.lbl.gov/", out.Destination)
+	assert.Equal(t, []string{"http://lbl.gov/", "http://www.lbl.gov/"}, out.Route)
+	assert.Equal(t, 200, out.StatusCode)
+
+	hsts, err := res.HSTSOutput()
+	require.NoError(t, err)
+	assert.Nil(t, hsts.MaxAge)
+}
@@ -0,0 +1,172 @@
+// retry.go
+//
+// Copyright 2018 © by Ollivier Robert <roberto@keltia.net>
+
+package observatory
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RetryPolicy controls how API calls failing with a transient error are retried:
+// network errors and the retryable HTTP status codes.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, 1 disables retries
+	MaxAttempts int
+	// MinBackoff is the delay before the first retry, doubled for each of the next ones
+	MinBackoff time.Duration
+	// MaxBackoff caps the delay, a longer Retry-After from the server stops the retries
+	MaxBackoff time.Duration
+	// Jitter randomizes the delay by ± this fraction, between 0 and 1
+	Jitter float64
+	// StatusCodes are the retryable HTTP status codes, DefaultRetryStatus if nil
+	StatusCodes []int
+}
+
+// DefaultRetryStatus are the HTTP status codes retried by default
+var DefaultRetryStatus = []int{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// DefaultRetryPolicy is used when no policy is given
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	MinBackoff:  time.Second,
+	MaxBackoff:  30 * time.Second,
+	Jitter:      0.2,
+}
+
+// validate checks the values of the policy
+func (p RetryPolicy) validate() error {
+	switch {
+	case p.MaxAttempts < 1:
+		return errors.Errorf("invalid number of attempts %d", p.MaxAttempts)
+	case p.MinBackoff < 0 || p.MaxBackoff < p.MinBackoff:
+		return errors.Errorf("invalid backoff %v-%v", p.MinBackoff, p.MaxBackoff)
+	case p.Jitter < 0 || p.Jitter > 1:
+		return errors.Errorf("invalid jitter %v", p.Jitter)
+	}
+	return nil
+}
+
+// backoff is the delay before the given retry, starting at 1
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	d := p.MinBackoff
+	for i := 1; i < retry && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+
+	if p.Jitter > 0 {
+		d = time.Duration(float64(d) * (1 + p.Jitter*(2*rand.Float64()-1)))
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// retryDelay tells whether the given attempt is to be retried and after how long
+func (c *Client) retryDelay(ctx context.Context, word string, attempt int, resp *http.Response, err error) (time.Duration, bool) {
+	if err == nil || attempt >= c.retry.MaxAttempts || ctx.Err() != nil {
+		return 0, false
+	}
+
+	// No answer, look at the error.  A POST may have reached the server anyway and
+	// submitting a scan twice fails with rescan-attempt-too-soon.
+	if resp == nil {
+		return c.retry.backoff(attempt), word != "POST" && retryError(err)
+	}
+
+	if !c.retry.retryStatus(resp.StatusCode) {
+		return 0, false
+	}
+
+	// Same for a POST behind a gateway, unless the server says it did nothing
+	if word == "POST" && !unprocessed(resp) {
+		return 0, false
+	}
+
+	// The server knows better, unless it is too long to wait
+	if d, ok := retryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+		return d, d <= c.retry.MaxBackoff
+	}
+	return c.retry.backoff(attempt), true
+}
+
+// unprocessed tells whether the answer shows the request was not processed at all
+func unprocessed(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusServiceUnavailable:
+		return resp.Header.Get("Retry-After") != ""
+	}
+	return false
+}
+
+// retryStatus checks whether the HTTP status code is worth a retry
+func (p RetryPolicy) retryStatus(code int) bool {
+	codes := p.StatusCodes
+	if codes == nil {
+		codes = DefaultRetryStatus
+	}
+
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// retryError checks whether the error from the HTTP client is a transient one
+func retryError(err error) bool {
+	// Every error from http.Client is an *url.Error, look inside
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		err = ue.Err
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var ne net.Error
+
+	return errors.As(err, &ne) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// retryAfter parses the Retry-After header, either seconds or a date
+func retryAfter(h string, now time.Time) (time.Duration, bool) {
+	if h == "" {
+		return 0, false
+	}
+
+	if secs, err := strconv.Atoi(h); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+
+	t, err := http.ParseTime(h)
+	if err != nil {
+		return 0, false
+	}
+	if d := t.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
@@ -0,0 +1,330 @@
+package observatory
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const finished = `{"scan_id":1,"state":"FINISHED","grade":"A","end_time":"Mon, 12 Aug 2024 08:20:18 GMT"}`
+
+// failServer accepts the POSTs, then answers the GETs with each of the status
+// codes in turn then always 200.  Only the GETs are counted.
+func failServer(calls *int32, codes ...int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			fmt.Fprint(w, `{"scan_id":1,"state":"PENDING"}`)
+			return
+		}
+
+		n := int(atomic.AddInt32(calls, 1))
+		if n <= len(codes) {
+			w.WriteHeader(codes[n-1])
+			return
+		}
+		fmt.Fprint(w, finished)
+	}))
+}
+
+var fastRetry = RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 5, MinBackoff: time.Second, MaxBackoff: 5 * time.Second}
+
+	assert.Equal(t, time.Second, p.backoff(1))
+	assert.Equal(t, 2*time.Second, p.backoff(2))
+	assert.Equal(t, 4*time.Second, p.backoff(3))
+	assert.Equal(t, 5*time.Second, p.backoff(4))
+	assert.Equal(t, 5*time.Second, p.backoff(40))
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := p.backoff(2)
+		assert.True(t, d >= time.Second && d <= 3*time.Second, "%v", d)
+	}
+}
+
+func TestRetryPolicy_Validate(t *testing.T) {
+	assert.NoError(t, DefaultRetryPolicy.validate())
+	assert.NoError(t, RetryPolicy{MaxAttempts: 1}.validate())
+
+	td := []RetryPolicy{
+		{},
+		{MaxAttempts: 3, MinBackoff: -time.Second},
+		{MaxAttempts: 3, MinBackoff: time.Second, MaxBackoff: time.Millisecond},
+		{MaxAttempts: 3, Jitter: 1.5},
+	}
+	for _, p := range td {
+		assert.Error(t, p.validate(), "%#v", p)
+
+		_, err := New(WithRetryPolicy(p))
+		assert.Error(t, err)
+	}
+}
+
+func TestRetryPolicy_RetryStatus(t *testing.T) {
+	p := DefaultRetryPolicy
+	assert.True(t, p.retryStatus(429))
+	assert.True(t, p.retryStatus(503))
+	assert.False(t, p.retryStatus(500))
+	assert.False(t, p.retryStatus(404))
+
+	p.StatusCodes = []int{500}
+	assert.True(t, p.retryStatus(500))
+	assert.False(t, p.retryStatus(503))
+}
+
+func TestRetryError(t *testing.T) {
+	neterr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+	assert.True(t, retryError(&url.Error{Op: "Get", URL: testURL, Err: neterr}))
+	assert.False(t, retryError(&url.Error{Op: "Get", URL: testURL, Err: context.Canceled}))
+	assert.False(t, retryError(&url.Error{Op: "Get", URL: testURL, Err: context.DeadlineExceeded}))
+	assert.False(t, retryError(&url.Error{Op: "Get", URL: testURL, Err: errors.New("redirect")}))
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 8, 12, 8, 0, 0, 0, time.UTC)
+
+	d, ok := retryAfter("", now)
+	assert.False(t, ok)
+
+	d, ok = retryAfter("120", now)
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Minute, d)
+
+	_, ok = retryAfter("-1", now)
+	assert.False(t, ok)
+
+	_, ok = retryAfter("soon", now)
+	assert.False(t, ok)
+
+	d, ok = retryAfter("Mon, 12 Aug 2024 08:00:30 GMT", now)
+	assert.True(t, ok)
+	assert.Equal(t, 30*time.Second, d)
+
+	d, ok = retryAfter("Mon, 12 Aug 2024 07:00:00 GMT", now)
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(0), d)
+}
+
+func TestClient_Retry(t *testing.T) {
+	var calls int32
+
+	srv := failServer(&calls, 503, 502)
+	defer srv.Close()
+
+	c, err := NewClient(Config{BaseURL: srv.URL, RetryPolicy: &fastRetry})
+	require.NoError(t, err)
+
+	grade, err := c.GetGrade("www.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "A", grade)
+	assert.EqualValues(t, 3, atomic.LoadInt32(&calls))
+}
+
+func TestClient_Retry_Post(t *testing.T) {
+	var posts int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			if atomic.AddInt32(&posts, 1) == 1 {
+				// The gateway gave up but the scan was queued
+				w.WriteHeader(http.StatusGatewayTimeout)
+				return
+			}
+			fmt.Fprint(w, `{"error":"rescan-attempt-too-soon"}`)
+			return
+		}
+		fmt.Fprint(w, finished)
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(Config{BaseURL: srv.URL, RetryPolicy: &fastRetry})
+	require.NoError(t, err)
+
+	// Not sent again
+	_, err = c.GetGrade("www.example.com")
+	var he *HTTPError
+	require.True(t, errors.As(err, &he))
+	assert.Equal(t, http.StatusGatewayTimeout, he.StatusCode)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&posts))
+}
+
+func TestClient_Retry_PostUnavailable(t *testing.T) {
+	var posts int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			if atomic.AddInt32(&posts, 1) == 1 {
+				// Nothing done, come back later
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprint(w, `{"scan_id":1,"state":"PENDING"}`)
+			return
+		}
+		fmt.Fprint(w, finished)
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(Config{BaseURL: srv.URL, RetryPolicy: &fastRetry})
+	require.NoError(t, err)
+
+	grade, err := c.GetGrade("www.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "A", grade)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&posts))
+}
+
+func TestClient_Retry_Exceeded(t *testing.T) {
+	var calls int32
+
+	srv := failServer(&calls, 503, 503, 503)
+	defer srv.Close()
+
+	c, err := NewClient(Config{BaseURL: srv.URL, RetryPolicy: &fastRetry})
+	require.NoError(t, err)
+
+	_, err = c.GetGrade("www.example.com")
+	require.Error(t, err)
+
+	var he *HTTPError
+	require.True(t, errors.As(err, &he))
+	assert.Equal(t, 503, he.StatusCode)
+	assert.EqualValues(t, 3, atomic.LoadInt32(&calls))
+}
+
+func TestClient_Retry_NotRetryable(t *testing.T) {
+	var calls int32
+
+	srv := failServer(&calls, 500)
+	defer srv.Close()
+
+	c, err := NewClient(Config{BaseURL: srv.URL, RetryPolicy: &fastRetry})
+	require.NoError(t, err)
+
+	_, err = c.GetGrade("www.example.com")
+	require.Error(t, err)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls))
+}
+
+func TestClient_Retry_RetryAfter(t *testing.T) {
+	var calls int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			fmt.Fprint(w, finished)
+		}
+	}))
+	defer srv.Close()
+
+	// Backoff would be way too long, Retry-After wins
+	c, err := NewClient(Config{
+		BaseURL:     srv.URL,
+		RetryPolicy: &RetryPolicy{MaxAttempts: 3, MinBackoff: time.Minute, MaxBackoff: time.Minute},
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// 2nd answer asks for more than MaxBackoff, we give up
+	_, err = c.GetGradeContext(ctx, "www.example.com")
+	require.Error(t, err)
+
+	var he *HTTPError
+	require.True(t, errors.As(err, &he))
+	assert.Equal(t, http.StatusTooManyRequests, he.StatusCode)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&calls))
+}
+
+func TestClient_Retry_Network(t *testing.T) {
+	var calls int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			fmt.Fprint(w, `{"scan_id":1,"state":"PENDING"}`)
+			return
+		}
+		if atomic.AddInt32(&calls, 1) == 1 {
+			// Drop the connection without answering
+			conn, _, err := w.(http.Hijacker).Hijack()
+			require.NoError(t, err)
+			conn.Close()
+			return
+		}
+		fmt.Fprint(w, finished)
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(Config{BaseURL: srv.URL, RetryPolicy: &fastRetry})
+	require.NoError(t, err)
+
+	grade, err := c.GetGrade("www.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "A", grade)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&calls))
+}
+
+func TestClient_Retry_NetworkPost(t *testing.T) {
+	var posts int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&posts, 1)
+
+		// The scan might have been submitted, we can not know
+		conn, _, err := w.(http.Hijacker).Hijack()
+		require.NoError(t, err)
+		conn.Close()
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(Config{BaseURL: srv.URL, RetryPolicy: &fastRetry})
+	require.NoError(t, err)
+
+	_, err = c.GetGrade("www.example.com")
+	assert.Error(t, err)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&posts))
+}
+
+func TestClient_MaxWait(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"scan_id":1,"state":"PENDING"}`)
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(Config{
+		BaseURL:      srv.URL,
+		Retries:      1000,
+		PollInterval: 10 * time.Millisecond,
+		MaxWait:      50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	start := time.Now()
+	_, err = c.GetGrade("www.example.com")
+	assert.True(t, errors.Is(err, ErrRetriesExceeded))
+	assert.True(t, time.Since(start) < time.Second)
+
+	_, err = New(WithMaxWait(0))
+	assert.Error(t, err)
+}
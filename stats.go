@@ -0,0 +1,109 @@
+// stats.go
+//
+// Copyright 2018 © by Ollivier Robert <roberto@keltia.net>
+
+package observatory
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// Grades is the list of all grades, from best to worst
+var Grades = []string{"A+", "A", "A-", "B+", "B", "B-", "C+", "C", "C-", "D+", "D", "D-", "F"}
+
+// GradeDistribution is the number of sites for each grade
+type GradeDistribution map[string]int
+
+// ScannerStates is the number of scans in each state
+type ScannerStates map[ScanState]int
+
+// Total returns the number of sites
+func (g GradeDistribution) Total() int {
+	n := 0
+	for _, v := range g {
+		n += v
+	}
+	return n
+}
+
+// Percentile returns the percentile rank of grade, i.e. the percentage of sites
+// with a worse grade, counting half of those with the same grade.
+func (g GradeDistribution) Percentile(grade string) (float64, error) {
+	total := g.Total()
+	if total == 0 {
+		return 0, errors.New("empty distribution")
+	}
+
+	var worse int
+
+	found := false
+	for _, gr := range Grades {
+		if found {
+			worse += g[gr]
+		}
+		if gr == grade {
+			found = true
+		}
+	}
+	if !found {
+		return 0, errors.Errorf("unknown grade %q", grade)
+	}
+
+	return 100 * (float64(worse) + float64(g[grade])/2) / float64(total), nil
+}
+
+// Queued returns the number of scans waiting to be run
+func (s ScannerStates) Queued() int {
+	return s[StatePending] + s[StateStarting]
+}
+
+// GetGradeDistribution returns the number of sites for each grade
+func (c *Client) GetGradeDistribution() (GradeDistribution, error) {
+	return c.GetGradeDistributionContext(context.Background())
+}
+
+// GetGradeDistributionContext is GetGradeDistribution with a context to cancel the call
+func (c *Client) GetGradeDistributionContext(ctx context.Context) (GradeDistribution, error) {
+	c.logger.Debug("GetGradeDistribution")
+
+	if c.api == 2 {
+		return nil, ErrNotSupported
+	}
+
+	raw, err := c.callAPI(ctx, "GET", "getGradeDistribution", nil, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetGradeDistribution")
+	}
+
+	var gd GradeDistribution
+
+	err = json.Unmarshal(raw, &gd)
+	return gd, errors.Wrap(err, "GetGradeDistribution/unmarshal")
+}
+
+// GetScannerStates returns the number of scans in each state
+func (c *Client) GetScannerStates() (ScannerStates, error) {
+	return c.GetScannerStatesContext(context.Background())
+}
+
+// GetScannerStatesContext is GetScannerStates with a context to cancel the call
+func (c *Client) GetScannerStatesContext(ctx context.Context) (ScannerStates, error) {
+	c.logger.Debug("GetScannerStates")
+
+	if c.api == 2 {
+		return nil, ErrNotSupported
+	}
+
+	raw, err := c.callAPI(ctx, "GET", "getScannerStates", nil, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetScannerStates")
+	}
+
+	var ss ScannerStates
+
+	err = json.Unmarshal(raw, &ss)
+	return ss, errors.Wrap(err, "GetScannerStates/unmarshal")
+}
@@ -0,0 +1,117 @@
+package observatory
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/h2non/gock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGradeDistribution_Percentile(t *testing.T) {
+	gd := GradeDistribution{"A+": 10, "A": 20, "B": 30, "F": 40}
+
+	assert.Equal(t, 100, gd.Total())
+
+	testData := []struct {
+		Grade string
+		Pct   float64
+	}{
+		{"A+", 95},
+		{"A", 80},
+		{"B", 55},
+		{"C", 40},
+		{"F", 20},
+	}
+
+	for _, td := range testData {
+		p, err := gd.Percentile(td.Grade)
+		require.NoError(t, err, td.Grade)
+		assert.InDelta(t, td.Pct, p, 0.001, td.Grade)
+	}
+
+	_, err := gd.Percentile("Z")
+	assert.Error(t, err)
+
+	_, err = GradeDistribution{}.Percentile("A")
+	assert.Error(t, err)
+}
+
+func TestScannerStates_Queued(t *testing.T) {
+	ss := ScannerStates{StatePending: 3, StateStarting: 2, StateRunning: 10, StateFinished: 1000}
+	assert.Equal(t, 5, ss.Queued())
+	assert.Equal(t, 0, ScannerStates{}.Queued())
+}
+
+func TestClient_GetGradeDistribution(t *testing.T) {
+	defer gock.Off()
+
+	ftr, err := ioutil.ReadFile("testdata/grade-distribution.json")
+	require.NoError(t, err)
+
+	gock.New(baseURL).
+		Get("getGradeDistribution").
+		Reply(200).
+		BodyString(string(ftr))
+
+	c, err := NewClient(Config{Timeout: 10})
+	require.NoError(t, err)
+
+	gock.InterceptClient(c.client)
+	defer gock.RestoreClient(c.client)
+
+	gd, err := c.GetGradeDistribution()
+	require.NoError(t, err)
+	assert.Len(t, gd, len(Grades))
+	assert.Equal(t, 3158, gd["A+"])
+	assert.Equal(t, 150391, gd["F"])
+	assert.Equal(t, 213910, gd.Total())
+
+	p, err := gd.Percentile("A+")
+	require.NoError(t, err)
+	assert.True(t, p > 99)
+}
+
+func TestClient_GetScannerStates(t *testing.T) {
+	defer gock.Off()
+
+	ftr, err := ioutil.ReadFile("testdata/scanner-states.json")
+	require.NoError(t, err)
+
+	gock.New(baseURL).
+		Get("getScannerStates").
+		Reply(200).
+		BodyString(string(ftr))
+
+	c, err := NewClient(Config{Timeout: 10})
+	require.NoError(t, err)
+
+	gock.InterceptClient(c.client)
+	defer gock.RestoreClient(c.client)
+
+	ss, err := c.GetScannerStates()
+	require.NoError(t, err)
+	assert.Equal(t, 122, ss[StatePending])
+	assert.Equal(t, 128, ss[StateRunning])
+	assert.Equal(t, 218, ss.Queued())
+}
+
+func TestClient_GetScannerStates_Error(t *testing.T) {
+	defer gock.Off()
+
+	gock.New(baseURL).
+		Get("getScannerStates").
+		Reply(200).
+		BodyString(`{"error":"database-down-try-again-soon"}`)
+
+	c, err := NewClient(Config{Timeout: 10})
+	require.NoError(t, err)
+
+	gock.InterceptClient(c.client)
+	defer gock.RestoreClient(c.client)
+
+	_, err = c.GetScannerStates()
+	assert.True(t, errors.Is(err, ErrScannerDown))
+}
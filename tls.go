@@ -0,0 +1,219 @@
+// tls.go
+//
+// Copyright 2018 © by Ollivier Robert <roberto@keltia.net>
+
+package observatory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	tlsBaseURL = "https://tls-observatory.services.mozilla.com/api/v1"
+
+	// DefaultTLSRetry is the number of retries for TLS scans, they take longer
+	DefaultTLSRetry = 30
+
+	// maxChain is the maximum length of a certificate chain we follow
+	maxChain = 10
+)
+
+// TLSClient is the client for the TLS Observatory API.
+// It is safe for concurrent use by multiple goroutines.
+type TLSClient struct {
+	c *Client
+}
+
+// TLSReport is the result of a complete TLS scan
+type TLSReport struct {
+	Results *TLSResults
+	// Chain is the certificate chain, leaf first
+	Chain []Certificate
+}
+
+// NewTLSClient setups the TLS Observatory client, same Config as NewClient
+func NewTLSClient(cnf ...Config) (*TLSClient, error) {
+	c, err := newClient(tlsBaseURL, DefaultTLSRetry, configOptions(cnf)...)
+	if err != nil {
+		return nil, errors.Wrap(err, "NewTLSClient")
+	}
+	return &TLSClient{c: c}, nil
+}
+
+// SubmitScan starts a scan of target and returns its ID
+func (t *TLSClient) SubmitScan(target string, rescan bool) (int, error) {
+	return t.SubmitScanContext(context.Background(), target, rescan)
+}
+
+// SubmitScanContext is SubmitScan with a context to cancel the call
+func (t *TLSClient) SubmitScanContext(ctx context.Context, target string, rescan bool) (int, error) {
+	t.c.logger.Debug("SubmitScan", "target", target)
+
+	if target == "" {
+		return 0, ErrEmptySite
+	}
+
+	body := url.Values{"target": {target}}
+	if rescan {
+		body.Set("rescan", "true")
+	}
+
+	raw, err := t.c.callAPI(ctx, "POST", "scan", body, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "SubmitScan")
+	}
+
+	var scan struct {
+		ID int `json:"scan_id"`
+	}
+
+	err = json.Unmarshal(raw, &scan)
+	return scan.ID, errors.Wrap(err, "SubmitScan/unmarshal")
+}
+
+// GetResults returns the current results of the scan, complete or not
+func (t *TLSClient) GetResults(id int) (*TLSResults, error) {
+	return t.GetResultsContext(context.Background(), id)
+}
+
+// GetResultsContext is GetResults with a context to cancel the call
+func (t *TLSClient) GetResultsContext(ctx context.Context, id int) (*TLSResults, error) {
+	t.c.logger.Debug("GetResults", "scan_id", id)
+
+	opts := map[string]string{
+		"id": fmt.Sprintf("%d", id),
+	}
+
+	raw, err := t.c.callAPI(ctx, "GET", "results", nil, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetResults")
+	}
+
+	var res TLSResults
+
+	err = json.Unmarshal(raw, &res)
+	return &res, errors.Wrap(err, "GetResults/unmarshal")
+}
+
+// WaitResults polls the scan until it is complete
+func (t *TLSClient) WaitResults(id int) (*TLSResults, error) {
+	return t.WaitResultsContext(context.Background(), id)
+}
+
+// WaitResultsContext is WaitResults with a context to cancel the wait
+func (t *TLSClient) WaitResultsContext(ctx context.Context, id int) (*TLSResults, error) {
+	deadline := t.c.pollDeadline()
+	for retry := 0; retry < t.c.retries; retry++ {
+		res, err := t.GetResultsContext(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		if res.CompletionPerc >= 100 {
+			return res, nil
+		}
+
+		t.c.logger.Debug("scan pending", "scan_id", id, "completion", res.CompletionPerc, "retry", retry)
+		if err := t.c.pollWait(ctx, deadline); err != nil {
+			return res, errors.Wrap(err, "WaitResults")
+		}
+	}
+	return nil, errors.Wrapf(ErrRetriesExceeded, "after %d tries", t.c.retries)
+}
+
+// GetCertificate returns the certificate with this ID
+func (t *TLSClient) GetCertificate(id int) (*Certificate, error) {
+	return t.GetCertificateContext(context.Background(), id)
+}
+
+// GetCertificateContext is GetCertificate with a context to cancel the call
+func (t *TLSClient) GetCertificateContext(ctx context.Context, id int) (*Certificate, error) {
+	t.c.logger.Debug("GetCertificate", "id", id)
+
+	opts := map[string]string{
+		"id": fmt.Sprintf("%d", id),
+	}
+
+	raw, err := t.c.callAPI(ctx, "GET", "certificate", nil, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetCertificate")
+	}
+
+	var cert Certificate
+
+	err = json.Unmarshal(raw, &cert)
+	return &cert, errors.Wrap(err, "GetCertificate/unmarshal")
+}
+
+// GetCertificateChain follows the issuers from the certificate with this ID, leaf first
+func (t *TLSClient) GetCertificateChain(id int) ([]Certificate, error) {
+	return t.GetCertificateChainContext(context.Background(), id)
+}
+
+// GetCertificateChainContext is GetCertificateChain with a context to cancel the calls
+func (t *TLSClient) GetCertificateChainContext(ctx context.Context, id int) ([]Certificate, error) {
+	var chain []Certificate
+
+	for len(chain) < maxChain && id != 0 {
+		cert, err := t.GetCertificateContext(ctx, id)
+		if err != nil {
+			return chain, err
+		}
+		chain = append(chain, *cert)
+
+		// Self-signed is the end
+		if cert.Issuer.ID == cert.ID {
+			break
+		}
+		id = cert.Issuer.ID
+	}
+	return chain, nil
+}
+
+// Scan submits a scan of target, waits for it and fetches the certificate chain
+func (t *TLSClient) Scan(target string) (*TLSReport, error) {
+	return t.ScanContext(context.Background(), target)
+}
+
+// ScanContext is Scan with a context to cancel the scan
+func (t *TLSClient) ScanContext(ctx context.Context, target string) (*TLSReport, error) {
+	id, err := t.SubmitScanContext(ctx, target, false)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := t.WaitResultsContext(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	chain, err := t.GetCertificateChainContext(ctx, res.CertID)
+	return &TLSReport{Results: res, Chain: chain}, err
+}
+
+// MozillaEvaluation returns the result of the mozillaEvaluationWorker analyzer
+func (r *TLSResults) MozillaEvaluation() (*MozillaEvaluation, error) {
+	for _, a := range r.Analysis {
+		if a.Analyzer != "mozillaEvaluationWorker" {
+			continue
+		}
+
+		var ev MozillaEvaluation
+
+		err := json.Unmarshal(a.Result, &ev)
+		return &ev, errors.Wrap(err, "MozillaEvaluation")
+	}
+	return nil, errors.New("no mozillaEvaluationWorker analysis")
+}
+
+// Compliant returns true if there is no failure for the configuration level
+// ("modern", "intermediate" or "old")
+func (e *MozillaEvaluation) Compliant(level string) bool {
+	f, ok := e.Failures[level]
+	return ok && len(f) == 0
+}
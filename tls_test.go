@@ -0,0 +1,186 @@
+package observatory
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/h2non/gock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mockTLS(t *testing.T, what, id, file string) {
+	ftr, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+
+	gock.New(tlsBaseURL).
+		Get(what).
+		MatchParam("id", id).
+		Reply(200).
+		BodyString(string(ftr))
+}
+
+func newTLSTest(t *testing.T) *TLSClient {
+	tc, err := NewTLSClient(Config{Timeout: 10})
+	require.NoError(t, err)
+
+	tc.c.poll = time.Millisecond
+	gock.InterceptClient(tc.c.client)
+	return tc
+}
+
+func TestNewTLSClient(t *testing.T) {
+	tc, err := NewTLSClient()
+	require.NoError(t, err)
+	assert.Equal(t, tlsBaseURL, tc.c.baseurl)
+	assert.Equal(t, DefaultTLSRetry, tc.c.retries)
+	assert.NotNil(t, tc.c.client)
+
+	tc, err = NewTLSClient(Config{BaseURL: testURL, Retries: 2})
+	require.NoError(t, err)
+	assert.Equal(t, testURL, tc.c.baseurl)
+	assert.Equal(t, 2, tc.c.retries)
+}
+
+func TestTLSClient_SubmitScan(t *testing.T) {
+	defer gock.Off()
+
+	ftr, err := ioutil.ReadFile("testdata/tls-scan.json")
+	require.NoError(t, err)
+
+	gock.New(tlsBaseURL).
+		Post("scan").
+		MatchHeader("content-type", "application/x-www-form-urlencoded").
+		BodyString("rescan=true&target=www.ssllabs.com").
+		Reply(200).
+		BodyString(string(ftr))
+
+	tc := newTLSTest(t)
+	defer gock.RestoreClient(tc.c.client)
+
+	id, err := tc.SubmitScan("www.ssllabs.com", true)
+	require.NoError(t, err)
+	assert.Equal(t, 41915731, id)
+
+	_, err = tc.SubmitScan("", false)
+	assert.Equal(t, ErrEmptySite, err)
+}
+
+func TestTLSClient_WaitResults(t *testing.T) {
+	defer gock.Off()
+
+	mockTLS(t, "results", "41915731", "testdata/tls-results-pending.json")
+	mockTLS(t, "results", "41915731", "testdata/tls-results.json")
+
+	tc := newTLSTest(t)
+	defer gock.RestoreClient(tc.c.client)
+
+	res, err := tc.WaitResults(41915731)
+	require.NoError(t, err)
+	assert.True(t, gock.IsDone())
+
+	assert.Equal(t, 100, res.CompletionPerc)
+	assert.True(t, res.HasTLS)
+	assert.True(t, res.IsValid)
+	assert.Equal(t, 265395838, res.CertID)
+	require.Len(t, res.ConnectionInfo.CipherSuites, 3)
+
+	cs := res.ConnectionInfo.CipherSuites[0]
+	assert.Equal(t, "ECDHE-RSA-AES256-GCM-SHA384", cs.Cipher)
+	assert.Equal(t, []string{"TLSv1.2"}, cs.Protocols)
+	assert.Equal(t, 2048, cs.PubKey)
+	assert.Equal(t, "ECDH,P-256,256bits", cs.PFS)
+
+	ev, err := res.MozillaEvaluation()
+	require.NoError(t, err)
+	assert.Equal(t, "old", ev.Level)
+	assert.True(t, ev.Compliant("old"))
+	assert.False(t, ev.Compliant("intermediate"))
+	assert.False(t, ev.Compliant("modern"))
+	assert.False(t, ev.Compliant("foo"))
+}
+
+func TestTLSClient_WaitResults_Retries(t *testing.T) {
+	defer gock.Off()
+
+	ftr, err := ioutil.ReadFile("testdata/tls-results-pending.json")
+	require.NoError(t, err)
+
+	gock.New(tlsBaseURL).
+		Get("results").
+		Persist().
+		Reply(200).
+		BodyString(string(ftr))
+
+	tc := newTLSTest(t)
+	defer gock.RestoreClient(tc.c.client)
+
+	tc.c.retries = 3
+
+	_, err = tc.WaitResultsContext(context.Background(), 41915731)
+	assert.True(t, errors.Is(err, ErrRetriesExceeded))
+}
+
+func TestTLSResults_NoEvaluation(t *testing.T) {
+	_, err := (&TLSResults{}).MozillaEvaluation()
+	assert.Error(t, err)
+}
+
+func TestTLSClient_GetCertificateChain(t *testing.T) {
+	defer gock.Off()
+
+	mockTLS(t, "certificate", "265395838", "testdata/tls-certificate.json")
+	mockTLS(t, "certificate", "31", "testdata/tls-certificate-ca.json")
+	mockTLS(t, "certificate", "4", "testdata/tls-certificate-root.json")
+
+	tc := newTLSTest(t)
+	defer gock.RestoreClient(tc.c.client)
+
+	chain, err := tc.GetCertificateChain(265395838)
+	require.NoError(t, err)
+	require.Len(t, chain, 3)
+	assert.True(t, gock.IsDone())
+
+	leaf := chain[0]
+	assert.Equal(t, "ssllabs.com", leaf.Subject.CN)
+	assert.Equal(t, "DigiCert SHA2 Secure Server CA", leaf.Issuer.CN)
+	assert.Contains(t, leaf.Extensions.SubjectAlternativeName, "www.ssllabs.com")
+	assert.Equal(t, "RSA", leaf.Key.Alg)
+	assert.Equal(t, 2048, leaf.Key.Size)
+	assert.False(t, leaf.CA)
+	assert.True(t, leaf.ValidationInfo["Mozilla"].IsValid)
+	assert.Equal(t, 2021, leaf.Validity.NotAfter.Year())
+
+	assert.True(t, chain[1].CA)
+	assert.Equal(t, "DigiCert Global Root CA", chain[2].Subject.CN)
+}
+
+func TestTLSClient_Scan(t *testing.T) {
+	defer gock.Off()
+
+	ftr, err := ioutil.ReadFile("testdata/tls-scan.json")
+	require.NoError(t, err)
+
+	gock.New(tlsBaseURL).
+		Post("scan").
+		BodyString("target=www.ssllabs.com").
+		Reply(200).
+		BodyString(string(ftr))
+
+	mockTLS(t, "results", "41915731", "testdata/tls-results.json")
+	mockTLS(t, "certificate", "265395838", "testdata/tls-certificate.json")
+	mockTLS(t, "certificate", "31", "testdata/tls-certificate-ca.json")
+	mockTLS(t, "certificate", "4", "testdata/tls-certificate-root.json")
+
+	tc := newTLSTest(t)
+	defer gock.RestoreClient(tc.c.client)
+
+	rep, err := tc.Scan("www.ssllabs.com")
+	require.NoError(t, err)
+	assert.True(t, gock.IsDone())
+	assert.Equal(t, "www.ssllabs.com", rep.Results.Target)
+	assert.Len(t, rep.Chain, 3)
+}
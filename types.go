@@ -1,11 +1,13 @@
 package observatory
 
 import (
+	"encoding/json"
 	"net/http"
 	"time"
 )
 
-// Client is used to store proxyauth & other internal state
+// Client is used to store proxyauth & other internal state.
+// It is safe for concurrent use by multiple goroutines.
 type Client struct {
 	baseurl   string
 	proxyauth string
@@ -13,9 +15,33 @@ type Client struct {
 	retries   int
 	client    *http.Client
 	timeout   time.Duration
+	poll      time.Duration
+	maxWait   time.Duration
+	retry     RetryPolicy
 
-	// Local cache for 5mn of last query
-	last *Analyze
+	// Client-side rate limit, nil for none
+	limiter *limiter
+	// Rescan cooldown of each site
+	cooldowns      *cooldowns
+	cooldownPolicy CooldownPolicy
+
+	// Local cache of the last analysis of each site
+	cache *cache
+	// Analyses in progress
+	flights *flights
+
+	// api is the Observatory API version, 1 or 2
+	api int
+	// scans maps the last scan ID of each site for the v2 API
+	scans *scanIndex
+
+	logger   Logger
+	agent    string
+	progress ProgressFunc
+
+	// Only used to build the HTTP client
+	transport http.RoundTripper
+	proxy     proxyConfig
 }
 
 // Config is for giving options to NewClient
@@ -24,6 +50,58 @@ type Config struct {
 	Timeout int
 	Retries int
 	Log     int
+
+	// Logger receives the log messages, Log is then ignored
+	Logger Logger
+	// Progress receives the events of each analysis
+	Progress ProgressFunc
+
+	// PollInterval is the delay between two checks of a scan not yet finished
+	PollInterval time.Duration
+	// MaxWait is the maximum time waiting for a scan to finish, no limit if zero
+	MaxWait time.Duration
+	// RetryPolicy is for the transient HTTP failures, DefaultRetryPolicy if nil
+	RetryPolicy *RetryPolicy
+
+	// RateLimit is the maximum number of API calls per second, no limit if zero
+	RateLimit float64
+	// RateBurst is the number of calls allowed at once, 1 if zero
+	RateBurst int
+	// RescanCooldown is the minimum delay between two rescans of a site, negative to disable
+	RescanCooldown time.Duration
+	// CooldownPolicy is what to do with a rescan during the cooldown
+	CooldownPolicy CooldownPolicy
+
+	// CacheTTL is how long an analysis is reused, negative to disable the cache
+	CacheTTL time.Duration
+	// CacheSize is the maximum number of sites in the cache
+	CacheSize int
+
+	// APIVersion selects the Observatory API: 1 (default) or 2 for the MDN HTTP Observatory
+	APIVersion int
+
+	// HTTPClient is used as-is for all requests, Timeout and proxy settings are ignored
+	HTTPClient *http.Client
+	// Transport replaces the default proxy-aware transport, ignored if HTTPClient is set
+	Transport http.RoundTripper
+
+	// Proxy is the proxy URL, overrides HTTP_PROXY/HTTPS_PROXY/NO_PROXY
+	Proxy string
+	// ProxyUser and ProxyPassword override the credentials from .netrc
+	ProxyUser     string
+	ProxyPassword string
+	// NoProxy is a comma-separated list of hosts never going through the proxy
+	NoProxy string
+}
+
+// ScanOptions is for giving options to the scan submission
+type ScanOptions struct {
+	// Hidden keeps the scan out of the public lists
+	Hidden bool `json:"hidden"`
+	// Rescan asks for a new scan even if a recent one exists
+	Rescan bool `json:"rescan"`
+	// NoWait returns the submission answer without waiting for the scan to finish
+	NoWait bool `json:"no_wait"`
 }
 
 // Analyze is for one run
@@ -37,10 +115,11 @@ type Analyze struct {
 	StartTime string `json:"start_time"`
 	EndTime   string `json:"end_time"`
 
-	State               string `json:"state"`
-	StatusCode          int    `json:"status_code"`
-	Hidden              bool   `json:"hidden"`
-	LikelihoodIndicator string `json:"likelihood_indicator"`
+	State               ScanState `json:"state"`
+	Error               string    `json:"error,omitempty"`
+	StatusCode          int       `json:"status_code"`
+	Hidden              bool      `json:"hidden"`
+	LikelihoodIndicator string    `json:"likelihood_indicator"`
 
 	TestsFailed   int `json:"tests_failed"`
 	TestsPassed   int `json:"tests_passed"`
@@ -49,15 +128,37 @@ type Analyze struct {
 	ResponseHeaders map[string]string `json:"response_headers"`
 }
 
+// ScanState is the state of an analysis as returned by the API
+type ScanState string
+
+// All the states an analysis can be in
+const (
+	StateAborted  ScanState = "ABORTED"
+	StateFailed   ScanState = "FAILED"
+	StateFinished ScanState = "FINISHED"
+	StatePending  ScanState = "PENDING"
+	StateRunning  ScanState = "RUNNING"
+	StateStarting ScanState = "STARTING"
+)
+
+// Done returns true if the analysis will not change state anymore
+func (s ScanState) Done() bool {
+	switch s {
+	case StateAborted, StateFailed, StateFinished:
+		return true
+	}
+	return false
+}
+
 // Scan for each individual tests
 type Scan struct {
-	Expectation      string `json:"expectation"`
-	Name             string `json:"name"`
-	Output           []byte `json:"-"`
-	Pass             bool   `json:"pass"`
-	Result           string `json:"result"`
-	ScoreDescription string `json:"score_description"`
-	ScoreModifier    int    `json:"score_modifier"`
+	Expectation      string          `json:"expectation"`
+	Name             string          `json:"name"`
+	Output           json.RawMessage `json:"output"`
+	Pass             bool            `json:"pass"`
+	Result           string          `json:"result"`
+	ScoreDescription string          `json:"score_description"`
+	ScoreModifier    int             `json:"score_modifier"`
 }
 
 // HostHistory for a given site
@@ -71,16 +172,185 @@ type HostHistory struct {
 
 // Result is all the test results.
 type Result struct {
-	ContentSecurityPolicy      Scan
-	Contribute                 Scan
-	Cookies                    Scan
-	CrossOriginResourceSharing Scan
-	PublicKeyPinning           Scan
-	Redirection                Scan
-	ReferrerPolicy             Scan
-	StrictTransportSecurity    Scan
-	SubresourceIntegrity       Scan
-	XContentTypeOptions        Scan
-	XFrameOptions              Scan
-	XXSSProtection             Scan
+	ContentSecurityPolicy      Scan `json:"content-security-policy"`
+	Contribute                 Scan `json:"contribute"`
+	Cookies                    Scan `json:"cookies"`
+	CrossOriginResourceSharing Scan `json:"cross-origin-resource-sharing"`
+	PublicKeyPinning           Scan `json:"public-key-pinning"`
+	Redirection                Scan `json:"redirection"`
+	ReferrerPolicy             Scan `json:"referrer-policy"`
+	StrictTransportSecurity    Scan `json:"strict-transport-security"`
+	SubresourceIntegrity       Scan `json:"subresource-integrity"`
+	XContentTypeOptions        Scan `json:"x-content-type-options"`
+	XFrameOptions              Scan `json:"x-frame-options"`
+	XXSSProtection             Scan `json:"x-xss-protection"`
+
+	// Others has the tests we do not know about yet
+	Others map[string]Scan `json:"-"`
+}
+
+// CSPOutput is the output of the content-security-policy test
+type CSPOutput struct {
+	// Data is the parsed policy, the list of sources for each directive
+	Data map[string][]string `json:"data"`
+	// HTTP is true if the policy came from the header
+	HTTP bool `json:"http"`
+	// Meta is true if the policy came from a <meta> tag
+	Meta bool `json:"meta"`
+	// Policy is the analysis, nil when there is no policy
+	Policy *CSPPolicy `json:"policy"`
+}
+
+// CSPPolicy is the analysis of the CSP policy
+type CSPPolicy struct {
+	AntiClickjacking      bool `json:"antiClickjacking"`
+	DefaultNone           bool `json:"defaultNone"`
+	InsecureBaseURI       bool `json:"insecureBaseUri"`
+	InsecureFormAction    bool `json:"insecureFormAction"`
+	InsecureSchemeActive  bool `json:"insecureSchemeActive"`
+	InsecureSchemePassive bool `json:"insecureSchemePassive"`
+	StrictDynamic         bool `json:"strictDynamic"`
+	UnsafeEval            bool `json:"unsafeEval"`
+	UnsafeInline          bool `json:"unsafeInline"`
+	UnsafeInlineStyle     bool `json:"unsafeInlineStyle"`
+	UnsafeObjects         bool `json:"unsafeObjects"`
+}
+
+// CookiesOutput is the output of the cookies test
+type CookiesOutput struct {
+	// Data has all the cookies, indexed by name
+	Data map[string]Cookie `json:"data"`
+	// SameSite is true if all cookies use the SameSite flag
+	SameSite bool `json:"sameSite"`
+}
+
+// Cookie is one of the cookies found by the cookies test
+type Cookie struct {
+	Domain   string `json:"domain"`
+	Path     string `json:"path"`
+	Port     *int   `json:"port"`
+	Secure   bool   `json:"secure"`
+	HTTPOnly bool   `json:"httponly"`
+	// SameSite is the value of the flag, empty if not set
+	SameSite string `json:"-"`
+	// Expires is the expiration date as a Unix timestamp, nil for session cookies
+	Expires *int64 `json:"expires"`
+	// MaxAge is in seconds, nil if not set
+	MaxAge *int64 `json:"-"`
+}
+
+// HSTSOutput is the output of the strict-transport-security test
+type HSTSOutput struct {
+	// Data is the raw header
+	Data              string `json:"data"`
+	MaxAge            *int64 `json:"max-age"`
+	IncludeSubDomains bool   `json:"includeSubDomains"`
+	// Preload is true if the header has the preload directive
+	Preload bool `json:"preload"`
+	// Preloaded is true if the site is in the browsers preload list
+	Preloaded bool `json:"preloaded"`
+}
+
+// RedirectionOutput is the output of the redirection test
+type RedirectionOutput struct {
+	Destination string   `json:"destination"`
+	Redirects   bool     `json:"redirects"`
+	Route       []string `json:"route"`
+	StatusCode  int      `json:"status_code"`
+}
+
+// TLSResults is the result of a TLS Observatory scan
+type TLSResults struct {
+	ID             int               `json:"id"`
+	Timestamp      time.Time         `json:"timestamp"`
+	Target         string            `json:"target"`
+	Replay         int               `json:"replay"`
+	HasTLS         bool              `json:"has_tls"`
+	CertID         int               `json:"cert_id"`
+	TrustID        int               `json:"trust_id"`
+	IsValid        bool              `json:"is_valid"`
+	CompletionPerc int               `json:"completion_perc"`
+	Ack            bool              `json:"ack"`
+	Attempts       int               `json:"attempts"`
+	ConnectionInfo TLSConnectionInfo `json:"connection_info"`
+	Analysis       []TLSAnalysis     `json:"analysis"`
+}
+
+// TLSConnectionInfo has the cipher suites accepted by the server
+type TLSConnectionInfo struct {
+	ScanIP         string        `json:"scanIP"`
+	ServerSide     bool          `json:"serverside"`
+	CipherSuites   []CipherSuite `json:"ciphersuite"`
+	CurvesFallback bool          `json:"curves_fallback"`
+}
+
+// CipherSuite is one of the cipher suites accepted by the server
+type CipherSuite struct {
+	Cipher       string   `json:"cipher"`
+	Code         int      `json:"code"`
+	Protocols    []string `json:"protocols"`
+	PubKey       int      `json:"pubkey"`
+	SigAlg       string   `json:"sigalg"`
+	TicketHint   string   `json:"ticket_hint"`
+	OCSPStapling bool     `json:"ocsp_stapling"`
+	PFS          string   `json:"pfs"`
+	Curves       []string `json:"curves"`
+}
+
+// TLSAnalysis is the output of one of the analyzers, Result depends on the analyzer
+type TLSAnalysis struct {
+	ID       int             `json:"id"`
+	Analyzer string          `json:"analyzer"`
+	Result   json.RawMessage `json:"result"`
+	Success  bool            `json:"success"`
+}
+
+// MozillaEvaluation is the result of the mozillaEvaluationWorker analyzer
+type MozillaEvaluation struct {
+	// Level is the Mozilla configuration level the server complies with
+	Level string `json:"level"`
+	// Failures is what prevents compliance, for each level
+	Failures map[string][]string `json:"failures"`
+}
+
+// Certificate is a certificate as stored by the TLS Observatory
+type Certificate struct {
+	ID                 int      `json:"id"`
+	SerialNumber       string   `json:"serialNumber"`
+	Version            int      `json:"version"`
+	SignatureAlgorithm string   `json:"signatureAlgorithm"`
+	Issuer             CertName `json:"issuer"`
+	Subject            CertName `json:"subject"`
+	Validity           struct {
+		NotBefore time.Time `json:"notBefore"`
+		NotAfter  time.Time `json:"notAfter"`
+	} `json:"validity"`
+	Key struct {
+		Alg      string `json:"alg"`
+		Size     int    `json:"size"`
+		Exponent int    `json:"exponent"`
+		Curve    string `json:"curve"`
+	} `json:"key"`
+	Extensions struct {
+		SubjectAlternativeName []string `json:"subjectAlternativeName"`
+	} `json:"x509v3Extensions"`
+	CA             bool                      `json:"ca"`
+	Hashes         map[string]string         `json:"hashes"`
+	ValidationInfo map[string]CertValidation `json:"validationInfo"`
+}
+
+// CertName is the subject or issuer of a certificate
+type CertName struct {
+	// ID is the TLS Observatory ID of the issuer certificate, if known
+	ID int      `json:"id"`
+	C  []string `json:"c"`
+	O  []string `json:"o"`
+	OU []string `json:"ou"`
+	CN string   `json:"cn"`
+}
+
+// CertValidation is the validation status of a certificate in a trust store
+type CertValidation struct {
+	IsValid         bool   `json:"isValid"`
+	ValidationError string `json:"validationError"`
 }
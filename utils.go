@@ -4,25 +4,102 @@
 
 package observatory
 
-import "log"
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
 
-// debug displays only if fDebug is set
-func (c *Client) debug(str string, a ...interface{}) {
-	if c.level >= 2 {
-		log.Printf(str, a...)
+// Logger is the structured logger used by the client, keyvals are key/value pairs.
+// *slog.Logger implements it.
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+// stdLogger is the default Logger, using the standard log package.  Level is
+// the old Config.Log: 0 silent, 1 verbose, 2 debug.
+type stdLogger struct {
+	level int
+}
+
+// Debug displays only in debug mode
+func (l *stdLogger) Debug(msg string, keyvals ...interface{}) {
+	if l.level >= 2 {
+		log.Print(format("DEBUG", msg, keyvals))
 	}
 }
 
-// debug displays only if fVerbose is set
-func (c *Client) verbose(str string, a ...interface{}) {
-	if c.level >= 1 {
-		log.Printf(str, a...)
+// Info displays only in verbose mode
+func (l *stdLogger) Info(msg string, keyvals ...interface{}) {
+	if l.level >= 1 {
+		log.Print(format("INFO", msg, keyvals))
 	}
 }
 
-// debug displays only if fVerbose is set
-func (c *Client) fatal(str string, a ...interface{}) {
-	if c.level >= 1 {
-		log.Fatalf(str, a...)
+// Error displays in verbose mode, a library does not write to stderr by itself
+func (l *stdLogger) Error(msg string, keyvals ...interface{}) {
+	if l.level >= 1 {
+		log.Print(format("ERROR", msg, keyvals))
+	}
+}
+
+// format gives "LEVEL msg key=value..." with values quoted if needed
+func format(level, msg string, keyvals []interface{}) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s %s", level, msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		var val interface{} = "!MISSING"
+		if i+1 < len(keyvals) {
+			val = keyvals[i+1]
+		}
+
+		str := fmt.Sprint(val)
+		if str == "" || strings.ContainsAny(str, " \t\n\"=") {
+			str = fmt.Sprintf("%q", str)
+		}
+		fmt.Fprintf(&b, " %v=%s", keyvals[i], str)
+	}
+	return b.String()
+}
+
+const redacted = "REDACTED"
+
+// redact hides secrets, only telling whether there is one
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redacted
+}
+
+// redactURL hides the password in u
+func redactURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	if _, ok := u.User.Password(); ok {
+		ru := *u
+		ru.User = url.UserPassword(u.User.Username(), redacted)
+		return ru.String()
+	}
+	return u.String()
+}
+
+// sensitiveHeaders are never logged as-is
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// redactHeaders returns a copy of h with the sensitive headers hidden
+func redactHeaders(h http.Header) http.Header {
+	rh := h.Clone()
+	for _, k := range sensitiveHeaders {
+		if rh.Get(k) != "" {
+			rh.Set(k, redacted)
+		}
 	}
+	return rh
 }
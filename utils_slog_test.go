@@ -0,0 +1,11 @@
+//go:build go1.21
+// +build go1.21
+
+package observatory
+
+import (
+	"log/slog"
+)
+
+// *slog.Logger can be used as is
+var _ Logger = (*slog.Logger)(nil)
@@ -0,0 +1,267 @@
+// v2.go
+//
+// Copyright 2018 © by Ollivier Robert <roberto@keltia.net>
+
+package observatory
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+/*
+The MDN HTTP Observatory (v2 API) has only two endpoints:
+
+- POST scan?host=  runs a scan and returns its summary once finished
+- GET analyze?host=  returns the last scan, its tests and the site history
+
+Everything is mapped onto the v1 types so the Client methods work the same.
+*/
+
+const (
+	baseURLv2 = "https://observatory-api.mdn.mozilla.net/api/v2"
+)
+
+// scanV2 is the summary of a scan in the v2 API
+type scanV2 struct {
+	ID               int       `json:"id"`
+	DetailsURL       string    `json:"details_url"`
+	AlgorithmVersion int       `json:"algorithm_version"`
+	ScannedAt        time.Time `json:"scanned_at"`
+	Error            string    `json:"error"`
+	Grade            string    `json:"grade"`
+	Score            int       `json:"score"`
+	StatusCode       int       `json:"status_code"`
+	TestsFailed      int       `json:"tests_failed"`
+	TestsPassed      int       `json:"tests_passed"`
+	TestsQuantity    int       `json:"tests_quantity"`
+}
+
+// historyV2 is one of the previous scans of a site
+type historyV2 struct {
+	ID        int       `json:"id"`
+	ScannedAt time.Time `json:"scanned_at"`
+	Grade     string    `json:"grade"`
+	Score     int       `json:"score"`
+}
+
+// analyzeV2 is what GET analyze returns
+type analyzeV2 struct {
+	Scan    scanV2                     `json:"scan"`
+	Tests   map[string]json.RawMessage `json:"tests"`
+	History []historyV2                `json:"history"`
+}
+
+// analyze maps the scan onto an Analyze
+func (s scanV2) analyze() *Analyze {
+	ar := &Analyze{
+		AlgorithmVersion: s.AlgorithmVersion,
+		Grade:            s.Grade,
+		Score:            s.Score,
+		ScanID:           s.ID,
+		StartTime:        s.ScannedAt.UTC().Format(time.RFC1123),
+		EndTime:          s.ScannedAt.UTC().Format(time.RFC1123),
+		State:            StateFinished,
+		StatusCode:       s.StatusCode,
+		TestsFailed:      s.TestsFailed,
+		TestsPassed:      s.TestsPassed,
+		TestsQuantity:    s.TestsQuantity,
+	}
+	if s.Error != "" {
+		ar.State = StateFailed
+		ar.Error = s.Error
+	}
+	return ar
+}
+
+// scanIndex maps scan IDs to sites, keeping only the last scan of each site as
+// the older ones have no tests anymore.  Safe for concurrent use.
+type scanIndex struct {
+	mu     sync.Mutex
+	sites  map[int]string
+	latest map[string]int
+}
+
+func newScanIndex() *scanIndex {
+	return &scanIndex{
+		sites:  map[int]string{},
+		latest: map[string]int{},
+	}
+}
+
+// store records id as the last scan of site
+func (s *scanIndex) store(id int, site string) {
+	site = strings.ToLower(site)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if old, ok := s.latest[site]; ok {
+		delete(s.sites, old)
+	}
+	s.latest[site] = id
+	s.sites[id] = site
+}
+
+// load returns the site of scan id
+func (s *scanIndex) load(id int) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	site, ok := s.sites[id]
+	return site, ok
+}
+
+// scanFields are the v2 test fields that go into Scan, the others are the output
+var scanFields = map[string]bool{
+	"expectation":       true,
+	"name":              true,
+	"pass":              true,
+	"result":            true,
+	"score_description": true,
+	"score_modifier":    true,
+}
+
+// testV1 converts a v2 test into the v1 format where the output is separate
+func testV1(raw json.RawMessage) (json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+
+	test := map[string]json.RawMessage{}
+	output := map[string]json.RawMessage{}
+	for k, v := range fields {
+		if scanFields[k] {
+			test[k] = v
+		} else {
+			output[k] = v
+		}
+	}
+
+	out, err := json.Marshal(output)
+	if err != nil {
+		return nil, err
+	}
+	test["output"] = out
+	return json.Marshal(test)
+}
+
+// getAnalyzeV2 runs a scan if sopts is given or fetches the last one
+func (c *Client) getAnalyzeV2(ctx context.Context, site string, sopts *ScanOptions) (*Analyze, error) {
+	var ar *Analyze
+
+	opts := map[string]string{
+		"host": site,
+	}
+
+	if sopts != nil {
+		raw, err := c.callAPI(ctx, "POST", "scan", nil, opts)
+		if err != nil {
+			return &Analyze{}, errors.Wrap(err, "post/scan")
+		}
+
+		var s scanV2
+
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return &Analyze{}, errors.Wrap(err, "unmarshall")
+		}
+		ar = s.analyze()
+	} else {
+		an, err := c.fetchAnalyzeV2(ctx, site)
+		if err != nil {
+			return &Analyze{}, err
+		}
+		ar = an.Scan.analyze()
+	}
+
+	c.scans.store(ar.ScanID, site)
+
+	if ar.State == StateFailed {
+		return ar, &APIError{Code: ar.Error, State: ar.State}
+	}
+
+	c.cache.put(site, ar)
+	return ar, nil
+}
+
+// fetchAnalyzeV2 gets the last scan of site, with tests and history
+func (c *Client) fetchAnalyzeV2(ctx context.Context, site string) (*analyzeV2, error) {
+	opts := map[string]string{
+		"host": site,
+	}
+
+	raw, err := c.callAPI(ctx, "GET", "analyze", nil, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "get/analyze")
+	}
+
+	var an analyzeV2
+
+	if err := json.Unmarshal(raw, &an); err != nil {
+		return nil, errors.Wrap(err, "unmarshall")
+	}
+	if an.Scan.ID != 0 {
+		c.scans.store(an.Scan.ID, site)
+	}
+	return &an, nil
+}
+
+// scanResultsV2 returns the tests of the scan in the v1 format.  The v2 API only
+// knows about sites so we need to have seen scanID before.
+func (c *Client) scanResultsV2(ctx context.Context, scanID int) ([]byte, error) {
+	site, ok := c.scans.load(scanID)
+	if !ok {
+		return nil, errors.Wrapf(ErrScanNotFound, "unknown scan %d", scanID)
+	}
+
+	an, err := c.fetchAnalyzeV2(ctx, site)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the last scan has its tests available
+	if an.Scan.ID != scanID {
+		return nil, errors.Wrapf(ErrScanNotFound, "scan %d replaced by %d", scanID, an.Scan.ID)
+	}
+
+	tests := map[string]json.RawMessage{}
+	for name, raw := range an.Tests {
+		t, err := testV1(raw)
+		if err != nil {
+			return nil, errors.Wrapf(err, "test %s", name)
+		}
+		tests[name] = t
+	}
+	return json.Marshal(tests)
+}
+
+// hostHistoryV2 maps the history of site onto HostHistory, oldest first
+func (c *Client) hostHistoryV2(ctx context.Context, site string) ([]HostHistory, error) {
+	an, err := c.fetchAnalyzeV2(ctx, site)
+	if err != nil {
+		return []HostHistory{}, err
+	}
+
+	hs := []HostHistory{}
+	for _, h := range an.History {
+		hs = append(hs, HostHistory{
+			EndTime:              h.ScannedAt.UTC().Format(time.RFC1123),
+			EndTimeUnixTimestamp: h.ScannedAt.Unix(),
+			Grade:                h.Grade,
+			ScanID:               h.ID,
+			Score:                h.Score,
+		})
+	}
+	sort.Slice(hs, func(i, j int) bool {
+		return hs[i].EndTimeUnixTimestamp < hs[j].EndTimeUnixTimestamp
+	})
+	return hs, nil
+}
@@ -0,0 +1,226 @@
+package observatory
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/h2non/gock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newV2Test(t *testing.T) *Client {
+	c, err := NewClient(Config{APIVersion: 2})
+	require.NoError(t, err)
+
+	gock.InterceptClient(c.client)
+	return c
+}
+
+func mockV2(t *testing.T, method, what, file string) {
+	ftr, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+
+	req := gock.New(baseURLv2)
+	if method == "POST" {
+		req.Post(what)
+	} else {
+		req.Get(what)
+	}
+	req.MatchParam("host", "mdn.dev").
+		Reply(200).
+		BodyString(string(ftr))
+}
+
+func TestNewClient_V2(t *testing.T) {
+	c, err := NewClient(Config{APIVersion: 2})
+	require.NoError(t, err)
+	assert.Equal(t, baseURLv2, c.baseurl)
+	assert.Equal(t, 2, c.api)
+
+	c, err = NewClient(Config{APIVersion: 2, BaseURL: testURL})
+	require.NoError(t, err)
+	assert.Equal(t, testURL, c.baseurl)
+
+	c, err = NewClient()
+	require.NoError(t, err)
+	assert.Equal(t, 1, c.api)
+
+	_, err = NewClient(Config{APIVersion: 3})
+	assert.Error(t, err)
+}
+
+func TestScanV2_Analyze(t *testing.T) {
+	ftr, err := ioutil.ReadFile("testdata/mdn-scan.json")
+	require.NoError(t, err)
+
+	var s scanV2
+
+	require.NoError(t, json.Unmarshal(ftr, &s))
+
+	ar := s.analyze()
+	assert.Equal(t, 77666718, ar.ScanID)
+	assert.Equal(t, "A+", ar.Grade)
+	assert.Equal(t, 105, ar.Score)
+	assert.Equal(t, StateFinished, ar.State)
+	assert.Equal(t, "Mon, 12 Aug 2024 08:20:18 UTC", ar.EndTime)
+	assert.Equal(t, 10, ar.TestsQuantity)
+
+	s.Error = "site down"
+	ar = s.analyze()
+	assert.Equal(t, StateFailed, ar.State)
+	assert.Equal(t, "site down", ar.Error)
+}
+
+func TestScanIndex(t *testing.T) {
+	s := newScanIndex()
+
+	s.store(1, "mdn.dev")
+	s.store(2, "example.com")
+
+	site, ok := s.load(1)
+	require.True(t, ok)
+	assert.Equal(t, "mdn.dev", site)
+
+	// Only the last scan of a site is kept
+	s.store(3, "MDN.dev")
+	_, ok = s.load(1)
+	assert.False(t, ok)
+
+	site, ok = s.load(3)
+	require.True(t, ok)
+	assert.Equal(t, "mdn.dev", site)
+	assert.Len(t, s.sites, 2)
+	assert.Len(t, s.latest, 2)
+}
+
+func TestTestV1(t *testing.T) {
+	raw, err := testV1(json.RawMessage(`{"name":"x-frame-options","pass":true,"title":"X-Frame-Options","data":"DENY"}`))
+	require.NoError(t, err)
+
+	var s Scan
+
+	require.NoError(t, json.Unmarshal(raw, &s))
+	assert.Equal(t, "x-frame-options", s.Name)
+	assert.True(t, s.Pass)
+	assert.JSONEq(t, `{"title":"X-Frame-Options","data":"DENY"}`, string(s.Output))
+
+	_, err = testV1(json.RawMessage(`[]`))
+	assert.Error(t, err)
+}
+
+func TestClient_GetGrade_V2(t *testing.T) {
+	defer gock.Off()
+
+	mockV2(t, "POST", "scan", "testdata/mdn-scan.json")
+
+	c := newV2Test(t)
+	defer gock.RestoreClient(c.client)
+
+	grade, err := c.GetGrade("mdn.dev")
+	require.NoError(t, err)
+	assert.Equal(t, "A+", grade)
+	assert.True(t, gock.IsDone())
+}
+
+func TestClient_GetGrade_V2Error(t *testing.T) {
+	defer gock.Off()
+
+	gock.New(baseURLv2).
+		Post("scan").
+		Reply(422).
+		BodyString(`{"error":"invalid-hostname-lookup","message":"mdn.invalid cannot be resolved"}`)
+
+	c := newV2Test(t)
+	defer gock.RestoreClient(c.client)
+
+	_, err := c.GetGrade("mdn.invalid")
+	assert.True(t, errors.Is(err, ErrInvalidHostname))
+	assert.Contains(t, err.Error(), "mdn.invalid cannot be resolved")
+}
+
+func TestClient_GetTestResults_V2(t *testing.T) {
+	defer gock.Off()
+
+	mockV2(t, "GET", "analyze", "testdata/mdn-analyze.json")
+	mockV2(t, "GET", "analyze", "testdata/mdn-analyze.json")
+
+	c := newV2Test(t)
+	defer gock.RestoreClient(c.client)
+
+	// Unknown scan
+	_, err := c.GetTestResults(77666718)
+	assert.True(t, errors.Is(err, ErrScanNotFound))
+
+	id, err := c.GetScanID("mdn.dev")
+	require.NoError(t, err)
+	assert.Equal(t, 77666718, id)
+
+	res, err := c.GetTestResults(id)
+	require.NoError(t, err)
+	assert.True(t, gock.IsDone())
+
+	assert.True(t, res.ContentSecurityPolicy.Pass)
+	assert.Equal(t, 5, res.ContentSecurityPolicy.ScoreModifier)
+	assert.Contains(t, res.Others, "cross-origin-resource-policy")
+
+	csp, err := res.CSPOutput()
+	require.NoError(t, err)
+	assert.True(t, csp.HTTP)
+	assert.True(t, csp.Policy.StrictDynamic)
+	assert.Equal(t, []string{"'self'"}, csp.Data["default-src"])
+
+	ok, err := res.HasHSTSPreload()
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	rd, err := res.RedirectionOutput()
+	require.NoError(t, err)
+	assert.Equal(t, "https://mdn.dev/", rd.Destination)
+}
+
+func TestClient_GetHostHistory_V2(t *testing.T) {
+	defer gock.Off()
+
+	mockV2(t, "GET", "analyze", "testdata/mdn-analyze.json")
+
+	c := newV2Test(t)
+	defer gock.RestoreClient(c.client)
+
+	hs, err := c.GetHostHistory("mdn.dev")
+	require.NoError(t, err)
+	require.Len(t, hs, 2)
+	assert.Equal(t, 70000001, hs[0].ScanID)
+	assert.Equal(t, "B", hs[0].Grade)
+	assert.Equal(t, "A+", hs[1].Grade)
+	assert.EqualValues(t, 1723450818, hs[1].EndTimeUnixTimestamp)
+}
+
+func TestClient_GetLatestAnalysis_V2(t *testing.T) {
+	defer gock.Off()
+
+	mockV2(t, "GET", "analyze", "testdata/mdn-analyze.json")
+
+	c := newV2Test(t)
+	defer gock.RestoreClient(c.client)
+
+	grade, err := c.GetCachedGrade("mdn.dev")
+	require.NoError(t, err)
+	assert.Equal(t, "A+", grade)
+}
+
+func TestClient_NotSupported_V2(t *testing.T) {
+	c := newV2Test(t)
+	defer gock.RestoreClient(c.client)
+
+	_, err := c.GetRecentScans(RecentScansFilter{})
+	assert.Equal(t, ErrNotSupported, err)
+
+	_, err = c.GetGradeDistribution()
+	assert.Equal(t, ErrNotSupported, err)
+
+	_, err = c.GetScannerStates()
+	assert.Equal(t, ErrNotSupported, err)
+}